@@ -0,0 +1,107 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Checkpoints
+
+// A checkpoint is an ordinary .ems file holding the seeds found so far,
+// conventionally named *.ems.partial, next to a JSON file of the same name
+// plus ".json" recording the progress of the run.
+
+// CheckpointJSON records the progress of an unfinished run. Seed seeds the
+// random source of the resumed run; the workers' own sources cannot be
+// captured mid-run, so a resumed run continues reproducibly from the
+// checkpoint but does not retrace the candidates an uninterrupted run would
+// have drawn.
+type CheckpointJSON struct {
+	Min            int     `json:"min"`
+	Max            int     `json:"max"`
+	HowMany        int     `json:"howmany"`
+	Found          int     `json:"found"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	Seed           int64   `json:"seed"`
+}
+
+// SaveCheckpoint writes seeds to path and the progress to path + ".json". If
+// the disk is full and the seeds land elsewhere (see SaveEMSFile), the
+// progress is written next to them.
+func SaveCheckpoint(path string, seeds []Seed, progress CheckpointJSON) error {
+	realmin, realmax := progress.Max, progress.Min
+	for _, s := range seeds {
+		if s.Depth < realmin {
+			realmin = s.Depth
+		}
+		if s.Depth > realmax {
+			realmax = s.Depth
+		}
+	}
+	pack, depths := PackSeedsWithDepths(seeds)
+	saved, err := SaveEMSFile(pack, depths, nil, progress.Min, progress.Max, realmin, realmax, path, false, 64, OrderLex)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(saved+".json", data, 0644)
+}
+
+// LoadCheckpoint reads back a checkpoint written by SaveCheckpoint. The depths
+// of the seeds are recomputed under formula f for the squared bailout radius b
+// unless the checkpoint stores them.
+func LoadCheckpoint(path string, b float64, f Formula) ([]Seed, CheckpointJSON, error) {
+	var progress CheckpointJSON
+
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, progress, err
+	}
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, progress, err
+	}
+
+	contents, err := LoadEMSContents(path)
+	if err != nil {
+		return nil, progress, err
+	}
+
+	seeds := make([]Seed, len(contents.Seeds))
+	for idx, c := range contents.Seeds {
+		if contents.Depths != nil {
+			seeds[idx] = Seed{C: c, Depth: contents.Depths[idx]}
+		} else {
+			// Only checkpoints older than -variant and -power lack depths.
+			seeds[idx] = Seed{C: c, Depth: SeedDepth(c, progress.Max+2, b, f)}
+		}
+	}
+	return seeds, progress, nil
+}
+
+// RemoveCheckpoint deletes the files of a checkpoint that is no longer needed.
+func RemoveCheckpoint(path string) {
+	os.Remove(path)
+	os.Remove(path + ".json")
+}
@@ -0,0 +1,408 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subcommands
+
+// commands maps the first command line argument to the subcommand it selects.
+// Without a known subcommand, EMSMiner mines.
+var commands = map[string]func(args []string){
+	"animate": AnimateCommand,
+	"filter":  FilterCommand,
+	"merge":   MergeCommand,
+	"render":  RenderCommand,
+	"thin":    ThinCommand,
+	"verify":  VerifyCommand,
+}
+
+// ParseCommandLine parses flags that may appear before, between or after the
+// positional arguments, and returns the positional arguments.
+func ParseCommandLine(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// CommandUsage prints the usage of a subcommand and exits.
+func CommandUsage(flags *flag.FlagSet, usage string) {
+	fmt.Println("\nUsage: " + filepath.Base(os.Args[0]) + " " + usage)
+	flags.PrintDefaults()
+	os.Exit(2)
+}
+
+// FormulaFlags adds the -variant and -power flags of the recurrence seeds were
+// mined with to flags, and returns a function giving that formula once flags
+// are parsed.
+func FormulaFlags(flags *flag.FlagSet) func() (Formula, error) {
+	variantflag := flags.String("variant", "mandelbrot", "recurrence the seeds were mined with: mandelbrot or tricorn")
+	power := flags.Int("power", 2, "exponent of the recurrence the seeds were mined with")
+	return func() (Formula, error) {
+		variant, err := ParseVariant(*variantflag)
+		if err != nil {
+			return Formula{}, err
+		}
+		return NewFormula(variant, *power)
+	}
+}
+
+// CommandFail reports err and exits.
+func CommandFail(err error) {
+	fmt.Println("Error: " + err.Error())
+	os.Exit(1)
+}
+
+// RenderCommand renders a previously saved .ems file to a PNG.
+func RenderCommand(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	width := flags.Int("width", 1024, "width of the output image in pixels")
+	height := flags.Int("height", 1024, "height of the output image in pixels")
+	fit := flags.Bool("fit", false, "frame the image around the seeds instead of the [-2,2]x[-2,2] plane")
+	colorflag := flags.Bool("color", false, "color the seeds by escape depth, recomputing depths the file does not store")
+	bailout := flags.Float64("bailout", 2.0, "escape radius used to recompute seed depths for -color")
+	parseFormula := FormulaFlags(flags)
+	positional := ParseCommandLine(flags, args)
+	formula, err := parseFormula()
+	if len(positional) != 2 || *width < 1 || *height < 1 || *bailout < 2 || err != nil {
+		CommandUsage(flags, "render [-width W] [-height H] [-fit] [-color] [-bailout B] [-variant V] [-power P] input.ems output.png")
+	}
+
+	contents, err := LoadEMSContents(positional[0])
+	if err != nil {
+		CommandFail(err)
+	}
+	seeds := contents.Seeds
+
+	minR, maxR, minI, maxI := FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI
+	if *fit {
+		minR, maxR, minI, maxI = seeds.FitBounds(0.02)
+	}
+	if *colorflag {
+		depths := contents.Depths
+		if depths == nil {
+			depths = SeedDepths(seeds, nil, *bailout**bailout, formula)
+		}
+		err = SavePNGFile(seeds.ToImageBounds(*width, *height, depths, nil, minR, maxR, minI, maxI), positional[1])
+	} else {
+		err = SavePNGFile(RenderSeedsBounds(seeds, *width, *height, minR, maxR, minI, maxI), positional[1])
+	}
+	if err != nil {
+		CommandFail(err)
+	}
+	fmt.Println("Rendered " + strconv.Itoa(len(seeds)) + " seeds from " + positional[0] + " to " + positional[1] + ".")
+}
+
+// AnimateCommand renders the escape-time fields of parameters morphing between
+// the consecutive seeds of a previously saved .ems file, sorted in the order
+// given by -sort, to numbered PNGs in a directory.
+func AnimateCommand(args []string) {
+	flags := flag.NewFlagSet("animate", flag.ExitOnError)
+	framesPerStep := flags.Int("frames-per-step", 24, "frames spent moving from one seed to the next")
+	width := flags.Int("width", 512, "width of the frames in pixels")
+	height := flags.Int("height", 512, "height of the frames in pixels")
+	limit := flags.Int("limit", 256, "iterations after which a point counts as never escaping")
+	bailout := flags.Float64("bailout", 2.0, "escape radius beyond which a point counts as escaped")
+	sortflag := flags.String("sort", "lex", "order to visit the seeds in: lex, depth, radius or none (as stored)")
+	parseFormula := FormulaFlags(flags)
+	positional := ParseCommandLine(flags, args)
+	formula, err := parseFormula()
+	order, sorterr := ParseSeedOrder(*sortflag)
+	if len(positional) != 2 || *framesPerStep < 1 || *width < 1 || *height < 1 || *limit < 1 || *bailout < 2 || err != nil || sorterr != nil {
+		CommandUsage(flags, "animate [-frames-per-step N] [-width W] [-height H] [-limit L] [-sort S] [-variant V] [-power P] input.ems outdir")
+	}
+
+	contents, err := LoadEMSContents(positional[0])
+	if err != nil {
+		CommandFail(err)
+	}
+	seeds, depths := contents.Seeds, contents.Depths
+	if order == OrderDepth && depths == nil {
+		depths = SeedDepths(seeds, nil, *bailout**bailout, formula)
+	}
+	if seeds, _, _, err = seeds.SortBy(order, depths, nil); err != nil {
+		CommandFail(err)
+	}
+
+	if err := os.MkdirAll(positional[1], 0755); err != nil {
+		CommandFail(err)
+	}
+	frames := seeds.AnimationFrames(*framesPerStep)
+	for idx, c := range frames {
+		if err := SavePNGFile(RenderEscapeField(c, *width, *height, *limit, *bailout**bailout, formula), filepath.Join(positional[1], fmt.Sprintf("frame%06d.png", idx))); err != nil {
+			CommandFail(err)
+		}
+	}
+	fmt.Println("Rendered " + strconv.Itoa(len(frames)) + " frames between " + strconv.Itoa(len(seeds)) + " seeds from " + positional[0] + " to " + positional[1] + ".")
+}
+
+// MergeCommand pools the seeds of several .ems files into one.
+func MergeCommand(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	bailout := flags.Float64("bailout", 2.0, "escape radius used to recompute the depth range of files without metadata")
+	parseFormula := FormulaFlags(flags)
+	positional := ParseCommandLine(flags, args)
+	formula, err := parseFormula()
+	if len(positional) < 2 || err != nil {
+		CommandUsage(flags, "merge [-bailout B] [-variant V] [-power P] out.ems|outdir in1.ems [in2.ems ...]")
+	}
+
+	// The merged file records the union of the ranges the inputs were mined
+	// for. Files that predate the metadata block only tell the range their
+	// seeds cover, which stands in for the requested one.
+	var merged seedpack
+	min, max := MaxSeedDepth, 0
+	realmin, realmax := MaxSeedDepth, 0
+	for _, path := range positional[1:] {
+		seeds, meta, err := LoadEMSFile(path)
+		if err != nil {
+			fmt.Println("Warning: skipping " + path + ": " + err.Error())
+			continue
+		}
+		fmt.Println("Read " + strconv.Itoa(len(seeds)) + " seeds from " + path + ".")
+		merged = append(merged, seeds...)
+
+		lo, hi := RealDepthRange(seeds, meta, *bailout**bailout, formula)
+		if lo < realmin {
+			realmin = lo
+		}
+		if hi > realmax {
+			realmax = hi
+		}
+		if meta.Version != 0 {
+			lo, hi = int(meta.Min), int(meta.Max)
+		}
+		if lo < min {
+			min = lo
+		}
+		if hi > max {
+			max = hi
+		}
+	}
+	if len(merged) == 0 {
+		CommandFail(errors.New("no seeds to merge"))
+	}
+
+	total := len(merged)
+	merged = merged.Sort().Dedup()
+
+	if _, err := SaveEMSFile(merged, nil, nil, min, max, realmin, realmax, positional[0], strings.HasSuffix(positional[0], ".gz"), 64, OrderLex); err != nil {
+		CommandFail(err)
+	}
+	fmt.Println("Merged " + strconv.Itoa(len(merged)) + " seeds (" + strconv.Itoa(total-len(merged)) + " duplicates dropped) into " + positional[0] + ".")
+}
+
+// FilterCommand keeps only the seeds of an .ems file within a depth range.
+func FilterCommand(args []string) {
+	flags := flag.NewFlagSet("filter", flag.ExitOnError)
+	min := flags.Int("min", 100, "minimum depth of seeds to keep")
+	max := flags.Int("max", 1000, "maximum depth of seeds to keep")
+	bailout := flags.Float64("bailout", 2.0, "escape radius used to recompute seed depths")
+	recompute := flags.Bool("recompute", false, "recompute seed depths even if the file stores them, e.g. for a different -bailout")
+	parseFormula := FormulaFlags(flags)
+	positional := ParseCommandLine(flags, args)
+	formula, err := parseFormula()
+	if len(positional) != 2 || *min < 2 || *max < *min || *bailout < 2 || err != nil {
+		CommandUsage(flags, "filter in.ems out.ems [-min M] [-max N] [-bailout B] [-variant V] [-power P] [-recompute]")
+	}
+
+	contents, err := LoadEMSContents(positional[0])
+	if err != nil {
+		CommandFail(err)
+	}
+	seeds, depths := contents.Seeds, contents.Depths
+	if *recompute {
+		depths = nil
+	}
+
+	filtered, kept, realmin, realmax := seeds.FilterWithDepths(depths, *min, *max, *bailout, formula)
+	if len(filtered) == 0 {
+		CommandFail(errors.New("no seeds of " + positional[0] + " have depths between " + strconv.Itoa(*min) + " - " + strconv.Itoa(*max)))
+	}
+
+	// Files that stored depths keep storing them, and files of float32 seeds
+	// stay so.
+	if contents.Depths == nil {
+		kept = nil
+	}
+	seedbits := 64
+	if contents.Meta.Flags&EMSFloat32 != 0 {
+		seedbits = 32
+	}
+	if _, err := SaveEMSFile(filtered, kept, nil, *min, *max, realmin, realmax, positional[1], strings.HasSuffix(positional[1], ".gz"), seedbits, OrderLex); err != nil {
+		CommandFail(err)
+	}
+	fmt.Println("Kept " + strconv.Itoa(len(filtered)) + " of " + strconv.Itoa(len(seeds)) + " seeds with depths between " + strconv.Itoa(*min) + " - " + strconv.Itoa(*max) + " in " + positional[1] + ".")
+}
+
+// ThinCommand keeps a uniformly random subset of the seeds of an .ems file,
+// along with their stored depths and smooth depths.
+func ThinCommand(args []string) {
+	flags := flag.NewFlagSet("thin", flag.ExitOnError)
+	n := flags.Int("n", 10000, "number of seeds to keep")
+	seed := flags.Int64("seed", 0, "random seed for a reproducible subset (0 seeds from the current time)")
+	bailout := flags.Float64("bailout", 2.0, "escape radius used to recompute the depth range of files without metadata")
+	parseFormula := FormulaFlags(flags)
+	positional := ParseCommandLine(flags, args)
+	formula, err := parseFormula()
+	if len(positional) != 2 || *n < 1 || *bailout < 2 || err != nil {
+		CommandUsage(flags, "thin in.ems out.ems [-n N] [-seed S] [-bailout B] [-variant V] [-power P]")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UTC().UnixNano()
+	}
+
+	contents, err := LoadEMSContents(positional[0])
+	if err != nil {
+		CommandFail(err)
+	}
+	seeds, meta := contents.Seeds, contents.Meta
+
+	picked := seeds.subsampleIndices(*n, rand.New(rand.NewSource(*seed)))
+	thinned := NewSeedpack(len(picked))
+	var depths []int
+	var smooth []float64
+	if contents.Depths != nil {
+		depths = make([]int, len(picked))
+	}
+	if contents.Smooth != nil {
+		smooth = make([]float64, len(picked))
+	}
+	for idx, pick := range picked {
+		thinned[idx] = seeds[pick]
+		if depths != nil {
+			depths[idx] = contents.Depths[pick]
+		}
+		if smooth != nil {
+			smooth[idx] = contents.Smooth[pick]
+		}
+	}
+
+	// The depth range of the subset is only known exactly if the depths are
+	// stored; otherwise that of the whole file is kept.
+	realmin, realmax := RealDepthRange(seeds, meta, *bailout**bailout, formula)
+	if len(depths) > 0 {
+		realmin, realmax = MaxSeedDepth, 0
+		for _, depth := range depths {
+			if depth < realmin {
+				realmin = depth
+			}
+			if depth > realmax {
+				realmax = depth
+			}
+		}
+	}
+	min, max := int(meta.Min), int(meta.Max)
+	if meta.Version == 0 {
+		min, max = realmin, realmax
+	}
+	seedbits := 64
+	if meta.Flags&EMSFloat32 != 0 {
+		seedbits = 32
+	}
+	if _, err := SaveEMSFile(thinned, depths, smooth, min, max, realmin, realmax, positional[1], strings.HasSuffix(positional[1], ".gz"), seedbits, OrderLex); err != nil {
+		CommandFail(err)
+	}
+	fmt.Println("Kept " + strconv.Itoa(len(thinned)) + " of " + strconv.Itoa(len(seeds)) + " seeds of " + positional[0] + " in " + positional[1] + ".")
+}
+
+// VerifyCommand recomputes the depth of every seed of an .ems file and checks
+// that it lies in the claimed depth range, by default the one recorded in the
+// file's metadata. Depths stored in the file are trusted unless -recompute is
+// given. It exits with status 1 if any seed does not.
+func VerifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	min := flags.Int("min", 0, "minimum depth every seed must have (default: the file's recorded minimum)")
+	max := flags.Int("max", 0, "maximum depth every seed may have (default: the file's recorded maximum)")
+	bailout := flags.Float64("bailout", 2.0, "escape radius used to recompute seed depths")
+	periodtol := flags.Float64("periodtol", DefaultPeriodTolerance, "distance within which a returning orbit counts as periodic, marking the seed interior")
+	recompute := flags.Bool("recompute", false, "recompute seed depths even if the file stores them")
+	parseFormula := FormulaFlags(flags)
+	positional := ParseCommandLine(flags, args)
+	formula, err := parseFormula()
+	if len(positional) != 1 || *min < 0 || *max < 0 || *bailout < 2 || *periodtol < 0 || err != nil {
+		CommandUsage(flags, "verify file.ems [-min M] [-max N] [-bailout B] [-periodtol T] [-variant V] [-power P] [-recompute]")
+	}
+
+	contents, err := LoadEMSContents(positional[0])
+	if err != nil {
+		CommandFail(err)
+	}
+	seeds, meta := contents.Seeds, contents.Meta
+	if contents.Depths != nil && !*recompute {
+		fmt.Println("Using the depths stored in " + positional[0] + "; pass -recompute to iterate the seeds instead.")
+	}
+	if *min == 0 || *max == 0 {
+		if meta.Version == 0 {
+			CommandFail(errors.New(positional[0] + " records no depth range; give one with -min and -max"))
+		}
+		if *min == 0 {
+			*min = int(meta.Min)
+		}
+		if *max == 0 {
+			*max = int(meta.Max)
+		}
+	}
+	if *max < *min {
+		CommandFail(errors.New("maximum depth " + strconv.Itoa(*max) + " is less than minimum depth " + strconv.Itoa(*min)))
+	}
+
+	inside, shallower, deeper, interior := 0, 0, 0, 0
+	for idx, c := range seeds {
+		var depth int
+		if contents.Depths != nil && !*recompute {
+			depth = contents.Depths[idx]
+		} else {
+			depth, _ = escapeDepth(c, *max, *bailout**bailout, *periodtol**periodtol, formula, nil)
+		}
+		switch {
+		case depth < 0:
+			interior++
+		case depth < *min:
+			shallower++
+		case depth > *max:
+			deeper++
+		default:
+			inside++
+		}
+	}
+
+	fmt.Println(strconv.Itoa(inside) + " of " + strconv.Itoa(len(seeds)) + " seeds in " + positional[0] + " have depths between " + strconv.Itoa(*min) + " - " + strconv.Itoa(*max) + ".")
+	if inside < len(seeds) {
+		fmt.Println(strconv.Itoa(shallower) + " are shallower, " + strconv.Itoa(deeper) + " are deeper and " + strconv.Itoa(interior) + " are interior points.")
+		os.Exit(1)
+	}
+}
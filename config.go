@@ -0,0 +1,159 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Config Files
+
+// Config holds the settings of a -config file: a JSON object whose keys are
+// flag names, without the dash, and whose values are what would follow the
+// flag on the command line, as strings, numbers or booleans of the flag's
+// type, with durations given as strings:
+//
+//	{"min": 100, "max": 1000, "howmany": 50000, "region": "-2,0.5,-1.25,1.25", "maxtime": "2h", "mirror": true}
+//
+// Every flag but -config has a field, named after the flag rather than its
+// aliases; a setting left out of the file is nil.
+type Config struct {
+	Min                *int     `json:"min,omitempty"`
+	Max                *int     `json:"max,omitempty"`
+	HowMany            *int     `json:"howmany,omitempty"`
+	Bailout            *float64 `json:"bailout,omitempty"`
+	GuideSize          *int     `json:"guidesize,omitempty"`
+	GuideTime          *int     `json:"guidetime,omitempty"`
+	NoGuidemap         *bool    `json:"no-guidemap,omitempty"`
+	GuideDilate        *int     `json:"guide-dilate,omitempty"`
+	WarmupGuidemapFrom *string  `json:"warmup-guidemap-from,omitempty"`
+	GuideOrbits        *bool    `json:"guide-orbits,omitempty"`
+	Guidemap           *string  `json:"guidemap,omitempty"`
+	DumpGuide          *string  `json:"dumpguide,omitempty"`
+	Out                *string  `json:"out,omitempty"`
+	Gzip               *bool    `json:"gz,omitempty"`
+	Format             *string  `json:"format,omitempty"`
+	PNG                *string  `json:"png,omitempty"`
+	Sort               *string  `json:"sort,omitempty"`
+	Stats              *bool    `json:"stats,omitempty"`
+	Dedup              *bool    `json:"dedup,omitempty"`
+	Append             *string  `json:"append,omitempty"`
+	Exclude            *string  `json:"exclude,omitempty"`
+	PrecisionOut       *int     `json:"precision-out,omitempty"`
+	Precision          *uint    `json:"precision,omitempty"`
+	MaxTime            *string  `json:"maxtime,omitempty"`
+	PeriodTol          *float64 `json:"periodtol,omitempty"`
+	Progress           *string  `json:"progress,omitempty"`
+	ProgressInterval   *string  `json:"progress-interval,omitempty"`
+	CountOnly          *int     `json:"count-only,omitempty"`
+	SeedsFromStdin     *bool    `json:"seeds-from-stdin,omitempty"`
+	RegionPreview      *string  `json:"region-preview,omitempty"`
+	PreviewSize        *int     `json:"preview-size,omitempty"`
+	Bench              *bool    `json:"bench,omitempty"`
+	ValidateRange      *bool    `json:"validate-range,omitempty"`
+	DryRun             *bool    `json:"dry-run,omitempty"`
+	Mirror             *bool    `json:"mirror,omitempty"`
+	Checkpoint         *string  `json:"checkpoint,omitempty"`
+	Resume             *string  `json:"resume,omitempty"`
+	Weighted           *bool    `json:"weighted,omitempty"`
+	Region             *string  `json:"region,omitempty"`
+	RMin               *float64 `json:"rmin,omitempty"`
+	RMax               *float64 `json:"rmax,omitempty"`
+	IMin               *float64 `json:"imin,omitempty"`
+	IMax               *float64 `json:"imax,omitempty"`
+	Tile               *string  `json:"tile,omitempty"`
+	Depths             *bool    `json:"depths,omitempty"`
+	Smooth             *bool    `json:"smooth,omitempty"`
+	MinMag             *float64 `json:"minmag,omitempty"`
+	MaxMag             *float64 `json:"maxmag,omitempty"`
+	MaxCandidates      *int64   `json:"max-candidates,omitempty"`
+	Neighbors          *int     `json:"neighbors,omitempty"`
+	NeighborRadius     *float64 `json:"neighbor-radius,omitempty"`
+	MaxDistance        *float64 `json:"max-distance,omitempty"`
+	MinSeparation      *float64 `json:"min-separation,omitempty"`
+	Power              *int     `json:"power,omitempty"`
+	Variant            *string  `json:"variant,omitempty"`
+	Sampler            *string  `json:"sampler,omitempty"`
+	Bias               *bool    `json:"bias,omitempty"`
+	Bins               *int     `json:"bins,omitempty"`
+	PerBin             *bool    `json:"per-bin,omitempty"`
+	Jobs               *string  `json:"jobs,omitempty"`
+	Threads            *int     `json:"threads,omitempty"`
+	Seed               *int64   `json:"seed,omitempty"`
+	CPUProfile         *string  `json:"cpuprofile,omitempty"`
+	MemProfile         *string  `json:"memprofile,omitempty"`
+	LogLevel           *string  `json:"loglevel,omitempty"`
+	LogFormat          *string  `json:"logformat,omitempty"`
+}
+
+// LoadConfig reads the config file at path, rejecting settings that are not
+// flags and values of the wrong type.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, errors.New(path + ": " + err.Error())
+	}
+	return config, nil
+}
+
+// Apply sets every flag of flags that the config sets to its value, except
+// those already given on the command line, which take precedence. Aliases,
+// such as -count for -howmany, set the same variable and so share one Value;
+// giving either on the command line overrides the config.
+func (this Config) Apply(flags *flag.FlagSet) error {
+	given := make(map[flag.Value]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Value] = true
+	})
+
+	settings := reflect.ValueOf(this)
+	for idx := 0; idx < settings.NumField(); idx++ {
+		setting := settings.Field(idx)
+		if setting.IsNil() {
+			continue
+		}
+		name, _, _ := strings.Cut(settings.Type().Field(idx).Tag.Get("json"), ",")
+		f := flags.Lookup(name)
+		if f == nil {
+			return errors.New("unknown setting \"" + name + "\"")
+		}
+		if given[f.Value] {
+			continue
+		}
+		value := fmt.Sprint(setting.Elem().Interface())
+		if err := flags.Set(name, value); err != nil {
+			return errors.New("setting \"" + name + "\" has invalid value " + strconv.Quote(value) + ": " + err.Error())
+		}
+	}
+	return nil
+}
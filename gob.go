@@ -0,0 +1,71 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+)
+
+// Gob Files
+
+// GobMetadata is the metadata stored with a seedpack by SaveGob: the requested
+// depth range [Min, Max] and the range [RealMin, RealMax] actually found.
+type GobMetadata struct {
+	Min, Max         int
+	RealMin, RealMax int
+}
+
+// gobFile is the value encoded by SaveGob. gob matches fields by name, so
+// fields can be added to it, or to GobMetadata, without breaking older files.
+type gobFile struct {
+	Meta  GobMetadata
+	Seeds []complex128
+}
+
+// SaveGob writes the seedpack and meta to path with encoding/gob. This is
+// meant for Go tools passing seeds among themselves; .ems files remain the
+// format to exchange seeds with anything else.
+func (this seedpack) SaveGob(path string, meta GobMetadata) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(gobFile{meta, this}); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadGob reads back a seedpack and its metadata written by SaveGob.
+func LoadGob(path string) (seedpack, GobMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, GobMetadata{}, err
+	}
+	defer file.Close()
+
+	var contents gobFile
+	if err := gob.NewDecoder(file).Decode(&contents); err != nil {
+		return nil, GobMetadata{}, errors.New(path + ": " + err.Error())
+	}
+	return contents.Seeds, contents.Meta, nil
+}
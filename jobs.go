@@ -0,0 +1,157 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Batch Jobs
+
+// Job is one entry of a -jobs file: howmany seeds with depths in [min, max],
+// saved to out, or to an automatically named file if out is empty.
+type Job struct {
+	Min     int    `json:"min"`
+	Max     int    `json:"max"`
+	HowMany int    `json:"howmany"`
+	Out     string `json:"out"`
+}
+
+// LoadJobs reads the jobs in path, which holds either a JSON array of jobs or
+// one JSON job per line.
+func LoadJobs(path string) ([]Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &jobs); err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var job Job
+			if err := decoder.Decode(&job); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, errors.New(path + ": job " + strconv.Itoa(len(jobs)+1) + ": " + err.Error())
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	if len(jobs) == 0 {
+		return nil, errors.New(path + ": no jobs")
+	}
+	for idx, job := range jobs {
+		if job.Min < 2 || job.Max < job.Min || job.HowMany < 1 {
+			return nil, errors.New(path + ": job " + strconv.Itoa(idx+1) + " asks for " + strconv.Itoa(job.HowMany) + " seeds with depths between " + strconv.Itoa(job.Min) + " - " + strconv.Itoa(job.Max))
+		}
+	}
+	return jobs, nil
+}
+
+// RunJobs mines the jobs one after another, each with the settings of
+// template but its own depth range and seed count, and hands the seeds found
+// to save. If ctx is done during a job, its seeds so far are still saved but
+// the remaining jobs are skipped.
+func RunJobs(ctx context.Context, jobs []Job, template Miner, save func(job Job, seeds []Seed, stats Stats)) {
+	type summary struct {
+		job   Job
+		stats Stats
+		out   string
+	}
+	summaries := make([]summary, 0, len(jobs))
+	skipped, reason := 0, error(nil)
+	for idx, job := range jobs {
+		miner := template
+		miner.Min, miner.Max, miner.HowMany = job.Min, job.Max, job.HowMany
+		miner.Checkpoint = nil
+
+		logger.Info("starting job", "job", idx+1, "jobs", len(jobs), "min", job.Min, "max", job.Max, "seeds", job.HowMany)
+		seeds, stats, err := miner.MineSeeds(ctx)
+		if len(seeds) > 0 {
+			save(job, seeds, stats)
+		}
+
+		out := job.Out
+		if out == "" {
+			out = "an automatically named file"
+		}
+		summaries = append(summaries, summary{job, stats, out})
+
+		if err != nil {
+			skipped, reason = len(jobs)-idx-1, err
+			break
+		}
+	}
+
+	for idx, summary := range summaries {
+		logger.Info("job summary", "job", idx+1, "found", summary.stats.Found, "target", summary.job.HowMany, "min", summary.job.Min, "max", summary.job.Max, "elapsed", FormatHMS(int(summary.stats.Elapsed/time.Second)), "out", summary.out)
+	}
+	if reason != nil {
+		logger.Warn("skipped the remaining jobs", "jobs", skipped, "err", reason)
+	}
+}
+
+// DepthBands splits the depths [min, max] into n bands of consecutive depths,
+// shallowest first, as equal in width as whole depths allow: the first bands
+// are one depth wider when the range does not divide evenly. n must be at most
+// max - min + 1.
+func DepthBands(min, max, n int) [][2]int {
+	bands := make([][2]int, n)
+	width, extra := (max-min+1)/n, (max-min+1)%n
+	lo := min
+	for idx := range bands {
+		hi := lo + width - 1
+		if idx < extra {
+			hi++
+		}
+		bands[idx] = [2]int{lo, hi}
+		lo = hi + 1
+	}
+	return bands
+}
+
+// BandFileName returns the file a depth band [lo, hi] of seeds meant for out
+// is saved to: out with "_<lo>-<hi>" inserted before its extension, or out
+// itself if it is empty or a directory, where automatically named files
+// already carry their depth range.
+func BandFileName(out string, lo, hi int) string {
+	if info, err := os.Stat(out); out == "" || (err == nil && info.IsDir()) {
+		return out
+	}
+	ext := filepath.Ext(out)
+	if ext == ".gz" {
+		ext = filepath.Ext(strings.TrimSuffix(out, ext)) + ext
+	}
+	return strings.TrimSuffix(out, ext) + "_" + strconv.Itoa(lo) + "-" + strconv.Itoa(hi) + ext
+}
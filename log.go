@@ -0,0 +1,53 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Logging
+
+// logger receives the messages EMSMiner reports while it runs, as opposed to
+// the results a command prints. It logs text records to stdout at the info
+// level until SetupLogging says otherwise.
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// SetupLogging points logger at w, dropping records below level, which is
+// debug, info, warn or error, and formatting them as format, text or json.
+func SetupLogging(w io.Writer, level, format string) error {
+	var minlevel slog.Level
+	if err := minlevel.UnmarshalText([]byte(level)); err != nil {
+		return errors.New("unknown log level \"" + level + "\"; expected debug, info, warn or error")
+	}
+
+	options := &slog.HandlerOptions{Level: minlevel}
+	switch format {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(w, options))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(w, options))
+	default:
+		return errors.New("unknown log format \"" + format + "\"; expected text or json")
+	}
+	return nil
+}
@@ -19,305 +19,2997 @@ package main
  *****************************************************************************/
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
 	"math"
 	"math/cmplx"
 	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 func main() {
 
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			PrintBanner()
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	min := flag.Int("min", 100, "minimum depth of seeds to mine")
 	max := flag.Int("max", 1000, "maximum depth of seeds to mine")
 	howmany := flag.Int("howmany", 1000000, "number of seeds to mine")
+	flag.IntVar(howmany, "count", *howmany, "alias for -howmany")
+	bailout := flag.Float64("bailout", 2.0, "escape radius beyond which an orbit counts as escaped (at least 2)")
+	guidesize := flag.Int("guidesize", 51, "width and height of the guidemap grid; larger sizes reject candidates more accurately but take longer to populate")
+	guidetime := flag.Int("guidetime", 60, "seconds spent generating the guidemap (0 disables the guidemap)")
+	noguidemap := flag.Bool("no-guidemap", false, "mine without a guidemap, neither generating nor checking one; chosen automatically for ranges shallower than 30 unless -guidetime is given")
+	guidedilate := flag.Int("guide-dilate", 0, "also let candidates through whose guidemap cell lies within this many cells of a marked one, sparing productive points just across a cell edge at a small lookup cost")
+	warmup := flag.String("warmup-guidemap-from", "", "comma-separated .ems files whose seeds mark the guidemap before it is generated for -guidetime seconds (0 then uses these seeds alone)")
+	guideorbits := flag.Bool("guide-orbits", false, "mark the guidemap cells along the escape orbit of every point found while generating it, mapping where orbits go rather than where seeds lie; much slower to generate")
+	guidemappath := flag.String("guidemap", "", "guidemap file to load, or to generate and save if it does not exist yet")
+	dumpguide := flag.String("dumpguide", "", "render the guidemap to this PNG file, one pixel per cell, before mining")
+	out := flag.String("out", "", "path of the output file, or a directory to place <min>-<max>_<md5>.<format> in (default: next to the executable)")
+	gz := flag.Bool("gz", false, "gzip-compress .ems output (named .ems.gz when named automatically)")
+	format := flag.String("format", "ems", "output format: ems (binary), csv (real,imag lines) or json (seeds with metadata)")
+	pngpath := flag.String("png", "", "also render the mined seeds as a 1024x1024 scatter plot to this PNG file")
+	sortflag := flag.String("sort", "lex", "order of the seeds in the output: lex (real, then imaginary part), depth, radius (distance from the origin) or none (as found); the MD5 in automatic file names depends on it")
+	spacingstats := flag.Bool("stats", false, "report the mean and least distance from every seed saved to its nearest neighbor")
+	dedup := flag.Bool("dedup", true, "drop bit-identical duplicate seeds before saving")
+	appendpath := flag.String("append", "", "existing .ems file to add the mined seeds to (rewritten in place)")
+	exclude := flag.String("exclude", "", "existing .ems file whose seeds' guidemap cells are skipped, steering the search towards new ground (heuristic, not an exact exclusion)")
+	precisionout := flag.Int("precision-out", 64, "bits per component of the seeds stored in .ems output: 64, or 32 to halve the file size (fine for shallow seeds, but loses deep ones)")
+	precision := flag.Uint("precision", 0, "mantissa bits for arbitrary-precision iteration of very deep seeds (only used above 53; much slower)")
+	maxtime := flag.Duration("maxtime", 0, "stop mining after this long (e.g. 10m) and save what was found (0 means no limit)")
+	periodtol := flag.Float64("periodtol", DefaultPeriodTolerance, "distance within which a returning orbit counts as periodic (0 requires an exact match)")
+	progress := flag.String("progress", "text", "progress reports: text (prose on stdout) or json (one object per line on stderr)")
+	progressinterval := flag.Duration("progress-interval", DefaultProgressInterval, "time between progress reports")
+	countonly := flag.Int("count-only", 0, "sample this many points of -region, print how many escape at each depth up to -max as CSV (JSON with -format json) and exit without mining")
+	seedsfromstdin := flag.Bool("seeds-from-stdin", false, "rather than mining, read candidate points from stdin, one \"r,i\" per line, write each with its escape depth and whether it lies within -min and -max to stdout, and save the accepted ones to -out")
+	regionpreview := flag.String("region-preview", "", "render the escape-time field of -region to this PNG file, coloring the points with depths between -min and -max, and exit without mining")
+	previewsize := flag.Int("preview-size", 1024, "length in pixels of the longer side of the -region-preview image")
+	bench := flag.Bool("bench", false, "mine a fixed workload of 100000 seeds with depths between 50 - 200, report the throughput of this machine and exit without saving")
+	validaterange := flag.Bool("validate-range", false, "before mining, make sure a seed in the depth range turns up among a few thousand candidates, and exit if none does")
+	dryrun := flag.Bool("dry-run", false, "mine briefly to estimate how long the full run would take, then exit without saving")
+	mirror := flag.Bool("mirror", false, "also keep the complex conjugate of every seed off the real axis, nearly doubling the yield")
+	checkpoint := flag.Duration("checkpoint", 0, "save the seeds found so far to a .ems.partial file this often (0 disables checkpoints)")
+	resume := flag.String("resume", "", "continue the run recorded in this .ems.partial checkpoint")
+	weighted := flag.Bool("weighted", false, "skip candidates in guidemap cells with few hits more often, favouring productive regions")
+	regionflag := flag.String("region", "", "rectangle minR,maxR,minI,maxI of the plane to sample candidates and build the guidemap in (default: the square holding the whole set of -power)")
+	rmin := flag.Float64("rmin", 0, "least real part of the candidates sampled, overriding that bound of -region (default: the bound of -region)")
+	rmax := flag.Float64("rmax", 0, "greatest real part of the candidates sampled, overriding that bound of -region (default: the bound of -region)")
+	imin := flag.Float64("imin", 0, "least imaginary part of the candidates sampled, overriding that bound of -region; set it to 0 with -mirror to sample the upper half-plane only (default: the bound of -region)")
+	imax := flag.Float64("imax", 0, "greatest imaginary part of the candidates sampled, overriding that bound of -region (default: the bound of -region)")
+	tile := flag.String("tile", "", "only sample the x-th of N vertical strips of -region, given as x/N; nodes mining 1/N through N/N together cover the region without overlap, and their outputs can be merged")
+	storedepths := flag.Bool("depths", false, "also store the escape depth of every seed in the .ems file, sparing filter and verify from recomputing it")
+	smooth := flag.Bool("smooth", false, "also compute the fractional (smooth) escape depth of every seed and store it in the output")
+	minmag := flag.Float64("minmag", 0, "only keep seeds at least this far from the origin")
+	maxmag := flag.Float64("maxmag", math.Inf(1), "only keep seeds at most this far from the origin")
+	maxcandidates := flag.Int64("max-candidates", 0, "give up after drawing this many candidates, saving the seeds found so far (0 means no limit)")
+	neighbors := flag.Int("neighbors", 0, "after every seed found, also look for up to this many seeds within -neighbor-radius of it (they count towards -howmany)")
+	neighborradius := flag.Float64("neighbor-radius", 1e-4, "distance from a seed within which -neighbors are looked for")
+	maxdistance := flag.Float64("max-distance", 0, "reject seeds whose estimated distance to the boundary of the set exceeds this, keeping only seeds hugging it (0 disables; estimates are rough, so start around 1e-3)")
+	minseparation := flag.Float64("min-separation", 0, "reject seeds closer than this to a seed already found (0 disables)")
+	power := flag.Int("power", 2, "exponent p of the recurrence z^p + c (at least 2; above 2 mines multibrots)")
+	variantflag := flag.String("variant", "mandelbrot", "recurrence to iterate: mandelbrot (z*z + c) or tricorn (conj(z)*conj(z) + c)")
+	samplerflag := flag.String("sampler", "random", "candidate sampler: random (independent uniform draws) or halton (low-discrepancy sequence, more even coverage)")
+	bias := flag.Bool("bias", false, "only draw candidates from guidemap cells that are marked or border a marked cell; raises acceptance for deep ranges but never samples cells the guidemap missed")
+	bins := flag.Int("bins", 1, "split the depth range into this many equal bands and save the seeds of each band to its own file, named with its range")
+	perbin := flag.Bool("per-bin", false, "with -bins, mine -howmany seeds for every band, one band after another, rather than -howmany in all")
+	jobsflag := flag.String("jobs", "", "JSON file of jobs {min, max, howmany, out}, as an array or one per line, to mine one after another instead of -min/-max/-howmany")
+	threads := flag.Int("threads", runtime.NumCPU(), "number of goroutines mining in parallel")
+	seed := flag.Int64("seed", 0, "random seed for reproducible mining (0 seeds from the current time)")
+	cpuprofile := flag.String("cpuprofile", "", "write a pprof CPU profile of mining to this file")
+	memprofile := flag.String("memprofile", "", "write a pprof heap profile to this file once mining stops")
+	loglevel := flag.String("loglevel", "info", "least severe log messages shown: debug, info, warn or error")
+	logformat := flag.String("logformat", "text", "log message format: text (key=value records) or json (one object per line)")
+	configpath := flag.String("config", "", "JSON file of settings keyed by flag name, e.g. {\"min\": 100, \"region\": \"-2,0.5,-1.25,1.25\"}; flags given on the command line override it")
 	flag.Parse()
 
-	fmt.Println("\nEMSMiner v0.2 Copyright (C) 2020 Daïm Aggott-Hönsch. This program comes with ABSOLUTELY NO WARRANTY.")
-	fmt.Println("This is free software, and you are welcome to redistribute it under the conditions specified by")
-	fmt.Println("the GNU General Public License 3 (https://www.gnu.org/licenses/gpl-3.0).")
+	if *configpath != "" {
+		config, err := LoadConfig(*configpath)
+		if err == nil {
+			err = config.Apply(flag.CommandLine)
+		}
+		if err != nil {
+			fmt.Println("Invalid -config: " + err.Error())
+			os.Exit(2)
+		}
+	}
+
+	// With -seeds-from-stdin, stdout carries the report, so it must not be
+	// interleaved with log records.
+	logout := os.Stdout
+	if *seedsfromstdin {
+		logout = os.Stderr
+	}
+	if err := SetupLogging(logout, *loglevel, *logformat); err != nil {
+		fmt.Println("Invalid logging options: " + err.Error())
+		os.Exit(2)
+	}
+
+	// Catch bad values here rather than letting MineSeeds reject them only after
+	// the guidemap has been generated.
+	if *howmany < 1 {
+		logger.Error("-howmany must be at least 1", "howmany", *howmany)
+		os.Exit(2)
+	}
+	if *min < 2 {
+		logger.Error("-min must be at least 2", "min", *min)
+		os.Exit(2)
+	}
+	if *max < *min {
+		logger.Error("-max must be at least -min", "min", *min, "max", *max)
+		os.Exit(2)
+	}
+	if *bailout < 2 {
+		logger.Error("-bailout must be at least 2", "bailout", *bailout)
+		os.Exit(2)
+	}
+	if *periodtol < 0 {
+		logger.Error("-periodtol must not be negative", "periodtol", *periodtol)
+		os.Exit(2)
+	}
+	if *threads < 1 {
+		logger.Error("-threads must be at least 1", "threads", *threads)
+		os.Exit(2)
+	}
+	if *previewsize < 1 {
+		logger.Error("-preview-size must be at least 1", "preview-size", *previewsize)
+		os.Exit(2)
+	}
+	if *bins < 1 {
+		logger.Error("-bins must be at least 1", "bins", *bins)
+		os.Exit(2)
+	}
+	if *guideorbits && (*noguidemap || *guidetime == 0) {
+		logger.Error("-guide-orbits needs a guidemap generated for some -guidetime")
+		os.Exit(2)
+	}
+	if *guidedilate < 0 {
+		logger.Error("-guide-dilate must not be negative", "guide-dilate", *guidedilate)
+		os.Exit(2)
+	}
+	if *guidesize < 1 || *guidetime < 0 {
+		logger.Error("-guidesize must be at least 1 and -guidetime not negative", "guidesize", *guidesize, "guidetime", *guidetime)
+		os.Exit(2)
+	}
+	if *minmag < 0 || *maxmag < *minmag {
+		logger.Error("-minmag must not be negative nor above -maxmag", "minmag", *minmag, "maxmag", *maxmag)
+		os.Exit(2)
+	}
+	if *maxdistance < 0 {
+		logger.Error("-max-distance must not be negative", "max-distance", *maxdistance)
+		os.Exit(2)
+	}
+	if *maxdistance > 0 && *precision > 53 {
+		logger.Error("-max-distance cannot be combined with arbitrary-precision iteration", "precision", *precision)
+		os.Exit(2)
+	}
+	if *minseparation < 0 {
+		logger.Error("-min-separation must not be negative", "min-separation", *minseparation)
+		os.Exit(2)
+	}
+	if *neighbors < 0 || (*neighbors > 0 && *neighborradius <= 0) {
+		logger.Error("-neighbors must not be negative, and -neighbor-radius must be positive", "neighbors", *neighbors, "neighbor-radius", *neighborradius)
+		os.Exit(2)
+	}
+	if *maxcandidates < 0 {
+		logger.Error("-max-candidates must not be negative", "max-candidates", *maxcandidates)
+		os.Exit(2)
+	}
+	if *progressinterval < 0 {
+		logger.Error("-progress-interval must not be negative", "progress-interval", *progressinterval)
+		os.Exit(2)
+	}
+
+	if *progress != "text" && *progress != "json" {
+		logger.Error("unknown progress format; expected text or json", "progress", *progress)
+		os.Exit(2)
+	}
+
+	variant, err := ParseVariant(*variantflag)
+	if err != nil {
+		logger.Error("invalid -variant", "err", err)
+		os.Exit(2)
+	}
+	formula, err := NewFormula(variant, *power)
+	if err != nil {
+		logger.Error("invalid -power", "err", err)
+		os.Exit(2)
+	}
+	if *power != 2 && *precision > 53 {
+		logger.Error("arbitrary-precision iteration only supports -power 2", "power", *power, "precision", *precision)
+		os.Exit(2)
+	}
+
+	region := formula.Bounds()
+	if *regionflag != "" {
+		if region, err = ParseRegion(*regionflag); err != nil {
+			logger.Error("invalid -region", "err", err)
+			os.Exit(2)
+		}
+	}
+	// Each bound given on its own overrides that side of the region only.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "rmin":
+			region.MinR = *rmin
+		case "rmax":
+			region.MaxR = *rmax
+		case "imin":
+			region.MinI = *imin
+		case "imax":
+			region.MaxI = *imax
+		}
+	})
+	if !(region.MinR < region.MaxR) || !(region.MinI < region.MaxI) {
+		logger.Error("the sampling region is empty; -rmin must be below -rmax and -imin below -imax", "region", region)
+		os.Exit(2)
+	}
+
+	if *tile != "" {
+		x, n, err := ParseTile(*tile)
+		if err != nil {
+			logger.Error("invalid -tile", "err", err)
+			os.Exit(2)
+		}
+		region = region.Strip(x, n)
+	}
+
+	if _, err := ParseSampler(*samplerflag, rng); err != nil {
+		logger.Error("invalid -sampler", "err", err)
+		os.Exit(2)
+	}
+
+	if *precisionout != 64 && *precisionout != 32 {
+		logger.Error("unknown -precision-out; expected 64 or 32", "precision-out", *precisionout)
+		os.Exit(2)
+	}
+	if *precisionout == 32 {
+		if *format != "ems" {
+			logger.Error("-precision-out 32 only applies to .ems output", "format", *format)
+			os.Exit(2)
+		}
+		logger.Warn("storing seeds as float32 pairs; deep seeds lose too much precision to survive this")
+	}
+
+	order, err := ParseSeedOrder(*sortflag)
+	if err != nil {
+		logger.Error("invalid -sort", "err", err)
+		os.Exit(2)
+	}
+
+	if *format != "ems" && *format != "csv" && *format != "json" {
+		logger.Error("unknown output format; expected ems, csv or json", "format", *format)
+		os.Exit(2)
+	}
+
+	var jobs []Job
+	if *jobsflag != "" {
+		if *resume != "" || *appendpath != "" || *checkpoint > 0 || *dryrun || *pngpath != "" {
+			logger.Error("-jobs cannot be combined with -resume, -append, -checkpoint, -dry-run or -png")
+			os.Exit(2)
+		}
+		if jobs, err = LoadJobs(*jobsflag); err != nil {
+			logger.Error("invalid -jobs", "err", err)
+			os.Exit(2)
+		}
+	}
+
+	if *bins > 1 {
+		if *jobsflag != "" || *appendpath != "" {
+			logger.Error("-bins cannot be combined with -jobs or -append")
+			os.Exit(2)
+		}
+		if *bins > *max-*min+1 {
+			logger.Error("-bins asks for more bands than there are depths in the range", "bins", *bins, "min", *min, "max", *max)
+			os.Exit(2)
+		}
+	}
+	// Mining howmany seeds per band is mining one job per band.
+	if *bins > 1 && *perbin {
+		if *resume != "" || *checkpoint > 0 || *dryrun || *pngpath != "" {
+			logger.Error("-per-bin cannot be combined with -resume, -checkpoint, -dry-run or -png")
+			os.Exit(2)
+		}
+		for _, band := range DepthBands(*min, *max, *bins) {
+			jobs = append(jobs, Job{Min: band[0], Max: band[1], HowMany: *howmany, Out: BandFileName(*out, band[0], band[1])})
+		}
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UTC().UnixNano()
+	}
+	SeedRandom(*seed)
+
+	// The census goes to stdout on its own, so it is taken before anything
+	// else is printed.
+	if *countonly > 0 {
+		sampler, _ := ParseSampler(*samplerflag, rng)
+		census := CountDepths(region, sampler, rng, *countonly, *max, *bailout**bailout, *periodtol**periodtol, formula, *threads)
+		if *format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(census)
+		} else {
+			census.WriteCSV(os.Stdout)
+		}
+		return
+	}
+
+	if *regionpreview != "" {
+		width, height := *previewsize, *previewsize
+		if aspect := (region.MaxR - region.MinR) / (region.MaxI - region.MinI); aspect > 1 {
+			height = int(math.Max(1, math.Round(float64(*previewsize)/aspect)))
+		} else {
+			width = int(math.Max(1, math.Round(float64(*previewsize)*aspect)))
+		}
+		if err := SavePNGFile(RenderRegion(region, width, height, *min, *max, *bailout**bailout, *periodtol**periodtol, formula, *threads), *regionpreview); err != nil {
+			logger.Error("cannot render region preview", "path", *regionpreview, "err", err)
+			os.Exit(1)
+		}
+		logger.Info("rendered region preview", "path", *regionpreview, "region", region, "width", width, "height", height)
+		return
+	}
+
+	// The report goes to stdout on its own, the log to stderr.
+	if *seedsfromstdin {
+		pack, depths, invalid, err := EvaluateCandidates(os.Stdin, os.Stdout, *min, *max, *bailout**bailout, *periodtol**periodtol, formula)
+		if err != nil {
+			logger.Error("cannot read candidates", "err", err)
+			os.Exit(1)
+		}
+		if len(pack) > 0 {
+			realmin, realmax := depths[0], depths[0]
+			for _, depth := range depths {
+				if depth < realmin {
+					realmin = depth
+				}
+				if depth > realmax {
+					realmax = depth
+				}
+			}
+			if !*storedepths {
+				depths = nil
+			}
+			saved, err := SaveSeedsAs(*format, pack, depths, nil, *min, *max, realmin, realmax, *out, *gz, *precisionout)
+			if err != nil {
+				logger.Error("cannot save seeds", "err", err)
+				os.Exit(1)
+			}
+			logger.Info("saved accepted candidates", "seeds", len(pack), "path", saved)
+		} else {
+			logger.Warn("no candidates accepted; nothing saved")
+		}
+		if invalid > 0 {
+			logger.Error("skipped malformed candidates", "lines", invalid)
+			os.Exit(1)
+		}
+		return
+	}
+
+	PrintBanner()
+
+	if *bench {
+		fmt.Println("")
+		stats := Benchmark(*threads)
+		fmt.Println("")
+		WriteBenchmark(os.Stdout, stats, *threads)
+		return
+	}
+
+	fmt.Println("\nUsage: " + filepath.Base(os.Args[0]) + " -min [minimum_depth] -max [maximum_depth] -howmany [number_of_seeds_wanted]")
+
+	fmt.Println("")
+
+	// Validate the file being appended to before spending hours mining.
+	var existing seedpack
+	var existingmeta EMSMetadata
+	if *appendpath != "" {
+		if *format != "ems" {
+			logger.Error("only .ems files can be appended to", "format", *format)
+			os.Exit(2)
+		}
+		var err error
+		if existing, existingmeta, err = LoadEMSFile(*appendpath); err != nil {
+			logger.Error("cannot append", "path", *appendpath, "err", err)
+			os.Exit(1)
+		}
+		logger.Info("appending", "path", *appendpath, "seeds", len(existing))
+	}
+
+	logger.Info("settings", "seed", *seed, "bailout", *bailout, "formula", formula.String(), "region", region)
+	if *precision > 53 {
+		logger.Info("using arbitrary-precision iteration", "bits", *precision)
+	}
+
+	// Ctrl-C stops mining early; whatever was found so far is still saved.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		cancel()
+	}()
+
+	// Seeds of a shallow range are common enough that generating a guidemap
+	// costs more time than it saves, unless its generation was asked for.
+	deepest, guidetimegiven := *max, false
+	for _, job := range jobs {
+		deepest = int(math.Max(float64(deepest), float64(job.Max)))
+	}
+	flag.Visit(func(f *flag.Flag) {
+		guidetimegiven = guidetimegiven || f.Name == "guidetime"
+	})
+	if !*noguidemap && deepest < ShallowDepth && !guidetimegiven && *guidemappath == "" && *warmup == "" && !*guideorbits {
+		logger.Info("depth range is shallow; skipping the guidemap", "max", deepest, "threshold", ShallowDepth)
+		*noguidemap = true
+	}
+
+	var guidemap *Guidemap
+	if *noguidemap {
+		if guidemap, err = GenerateGuidemapBounds(*guidesize, *guidesize, region.MinR, region.MaxR, region.MinI, region.MaxI, 0, formula, *weighted); err != nil {
+			logger.Error("cannot generate guidemap", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		if _, err := os.Stat(*guidemappath); *guidemappath != "" && err == nil {
+			loaded, err := LoadGuidemap(*guidemappath)
+			if err != nil {
+				logger.Error("cannot load guidemap", "path", *guidemappath, "err", err)
+				os.Exit(1)
+			}
+			// A guidemap generated for other settings would steer the search
+			// towards the wrong cells, or reject whole regions outright.
+			if err := loaded.Matches(*guidesize, *guidesize, region, formula); err != nil {
+				logger.Warn("guidemap does not match the current settings; regenerating it", "path", *guidemappath, "err", err)
+			} else {
+				guidemap = loaded
+				logger.Info("loaded guidemap", "path", *guidemappath, "width", guidemap.itsWidth, "height", guidemap.itsHeight)
+			}
+		}
+		if guidemap == nil {
+			if *warmup != "" || *guideorbits {
+				guidemap = NewGuidemap(*guidesize, *guidesize, region.MinR, region.MaxR, region.MinI, region.MaxI, *weighted)
+				if *guideorbits {
+					guidemap.TrackOrbits()
+				}
+				if *warmup != "" {
+					paths := strings.Split(*warmup, ",")
+					marked := 0
+					for _, path := range paths {
+						seeds, _, err := LoadEMSFile(path)
+						if err != nil {
+							logger.Error("cannot warm up the guidemap", "path", path, "err", err)
+							os.Exit(1)
+						}
+						for _, c := range seeds {
+							if guidemap.Contains(c) {
+								guidemap.Mark(c)
+								marked++
+							}
+						}
+					}
+					logger.Info("warmed up guidemap from earlier seeds", "files", len(paths), "seeds", marked, "fillpercent", math.Round(guidemap.FillRatio()*10000)/100)
+				}
+				if *guidetime > 0 {
+					guidemap.Generate(*guidetime, formula)
+				}
+			} else {
+				if guidemap, err = GenerateGuidemapBounds(*guidesize, *guidesize, region.MinR, region.MaxR, region.MinI, region.MaxI, *guidetime, formula, *weighted); err != nil {
+					logger.Error("cannot generate guidemap", "err", err)
+					os.Exit(1)
+				}
+			}
+			if *guidemappath != "" {
+				if err := guidemap.SaveGuidemap(*guidemappath); err != nil {
+					logger.Error("cannot save guidemap", "path", *guidemappath, "err", err)
+					os.Exit(1)
+				}
+				logger.Info("saved guidemap", "path", *guidemappath)
+			}
+		}
+	}
+	// Excluding the cells of earlier seeds is only a heuristic: the guidemap
+	// rejects candidates in an unmarked cell only after a few iterations, so
+	// shallow seeds can still be found there, and once found they mark the
+	// cell again. New seeds may also lie arbitrarily close to excluded ones
+	// across a cell boundary.
+	if *exclude != "" {
+		excluded, _, err := LoadEMSFile(*exclude)
+		if err != nil {
+			logger.Error("cannot exclude seeds", "path", *exclude, "err", err)
+			os.Exit(1)
+		}
+		for _, c := range excluded {
+			guidemap.Unmark(c)
+		}
+		logger.Info("excluded guidemap cells of earlier seeds", "path", *exclude, "seeds", len(excluded))
+	}
+	if *guidedilate > 0 {
+		guidemap.Dilate(*guidedilate)
+	}
+	if *dumpguide != "" {
+		if err := SavePNGFile(guidemap.Render(), *dumpguide); err != nil {
+			logger.Warn("cannot render guidemap", "path", *dumpguide, "err", err)
+		} else {
+			logger.Info("rendered guidemap", "path", *dumpguide)
+		}
+	}
+
+	if *maxtime > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, *maxtime)
+		defer cancelTimeout()
+	}
+	// A resumed run takes its parameters from the checkpoint.
+	var resumed []Seed
+	progressSoFar := CheckpointJSON{}
+	if *resume != "" {
+		var err error
+		if resumed, progressSoFar, err = LoadCheckpoint(*resume, *bailout**bailout, formula); err != nil {
+			logger.Error("cannot resume", "path", *resume, "err", err)
+			os.Exit(1)
+		}
+		if len(resumed) >= progressSoFar.HowMany {
+			logger.Error("checkpoint already holds all seeds", "path", *resume, "seeds", progressSoFar.HowMany)
+			os.Exit(1)
+		}
+		*min, *max, *howmany = progressSoFar.Min, progressSoFar.Max, progressSoFar.HowMany
+		logger.Info("resuming", "path", *resume, "seeds", len(resumed), "target", *howmany)
+	}
+
+	checkpointpath := *resume
+	if checkpointpath == "" && *out != "" {
+		checkpointpath = *out + ".partial"
+	} else if checkpointpath == "" {
+		dir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
+		checkpointpath = filepath.Join(dir, strconv.Itoa(*min)+"-"+strconv.Itoa(*max)+".ems.partial")
+	}
+
+	miner := NewMiner(*howmany-len(resumed), *min, *max)
+	if *resume != "" {
+		miner.Rand = rand.New(rand.NewSource(progressSoFar.Seed))
+	}
+	if *checkpoint > 0 {
+		miner.CheckpointInterval = *checkpoint
+		miner.Checkpoint = func(seeds []Seed, stats Stats, resumeSeed int64) {
+			err := SaveCheckpoint(checkpointpath, append(append([]Seed(nil), resumed...), seeds...), CheckpointJSON{
+				Min:            *min,
+				Max:            *max,
+				HowMany:        *howmany,
+				Found:          len(resumed) + len(seeds),
+				ElapsedSeconds: progressSoFar.ElapsedSeconds + stats.Elapsed.Seconds(),
+				Seed:           resumeSeed,
+			})
+			if err != nil {
+				logger.Warn("cannot save checkpoint", "path", checkpointpath, "err", err)
+			}
+		}
+	}
+	miner.Threads = *threads
+	miner.Bailout = *bailout
+	miner.Tolerance = *periodtol
+	miner.Precision = *precision
+	miner.Guidemap = guidemap
+	miner.Progress = *progress
+	miner.ProgressInterval = *progressinterval
+	miner.Mirror = *mirror
+	miner.Region = region
+	miner.Weighted = *weighted
+	miner.Smooth = *smooth
+	miner.MinMag = *minmag
+	miner.MaxMag = *maxmag
+	miner.MinSeparation = *minseparation
+	miner.MaxDistance = *maxdistance
+	miner.MaxCandidates = *maxcandidates
+	miner.Neighbors, miner.NeighborRadius = *neighbors, *neighborradius
+	miner.Variant = variant
+	miner.Power = *power
+	miner.Sampler, _ = ParseSampler(*samplerflag, rng)
+	if *bias {
+		miner.Sampler = NewBiasedSampler(miner.Sampler, guidemap)
+	}
+
+	if *validaterange {
+		ranges := []Job{{Min: *min, Max: *max}}
+		if jobs != nil {
+			ranges = jobs
+		}
+		for _, job := range ranges {
+			preflight := *miner
+			preflight.Min, preflight.Max = job.Min, job.Max
+			if !preflight.ReachesRange(ctx, PreflightCandidates) {
+				logger.Error("no seeds found in the depth range; widen the range or lower -min", "min", job.Min, "max", job.Max, "candidates", PreflightCandidates)
+				os.Exit(1)
+			}
+		}
+	}
+
+	stopProfiling, err := StartProfiling(*cpuprofile, *memprofile)
+	if err != nil {
+		logger.Error("cannot start profiling", "err", err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
+	if jobs != nil {
+		RunJobs(ctx, jobs, *miner, func(job Job, seeds []Seed, stats Stats) {
+			pack := PackSeeds(seeds)
+			if *dedup {
+				pack = pack.DedupUnsorted()
+			}
+			var smoothdepths []float64
+			if *smooth {
+				smoothdepths = SmoothDepths(pack, seeds, *bailout**bailout, formula)
+			}
+			var seeddepths []int
+			if *storedepths || order == OrderDepth {
+				seeddepths = SeedDepths(pack, seeds, *bailout**bailout, formula)
+			}
+			pack, seeddepths, smoothdepths, err := pack.SortBy(order, seeddepths, smoothdepths)
+			if err != nil {
+				logger.Error("cannot sort seeds", "min", job.Min, "max", job.Max, "err", err)
+				return
+			}
+			if !*storedepths {
+				seeddepths = nil
+			}
+			saved, err := SaveSeedsAs(*format, pack, seeddepths, smoothdepths, job.Min, job.Max, stats.RealMin, stats.RealMax, job.Out, *gz, *precisionout)
+			if err != nil {
+				logger.Error("cannot save seeds", "min", job.Min, "max", job.Max, "err", err)
+				return
+			}
+			manifest := filepath.Join(filepath.Dir(saved), ManifestName)
+			if err := AppendManifest(manifest, saved, job.Min, job.Max, stats.RealMin, stats.RealMax, len(pack)); err != nil {
+				logger.Warn("cannot update manifest", "path", manifest, "err", err)
+			}
+		})
+		return
+	}
+
+	if *dryrun {
+		logger.Info("dry run: calibrating", "duration", DryRunCalibration)
+		calibration, cancelCalibration := context.WithTimeout(ctx, DryRunCalibration)
+		_, stats, _ := miner.MineSeeds(calibration)
+		cancelCalibration()
+		stopProfiling()
+		if stats.Found == 0 {
+			logger.Warn("no seeds found during calibration; the depth range may be unreachable")
+			return
+		}
+		projected := int(float64(*howmany) / stats.SeedsPerHour * 60 * 60)
+		logger.Info("projected time", "seeds", *howmany, "time", FormatHMS(projected), "sph", int(stats.SeedsPerHour))
+		return
+	}
+
+	seeds, stats, err := miner.MineSeeds(ctx)
+	stopProfiling()
+	if errors.Is(err, ErrInvalidCount) || errors.Is(err, ErrInvalidRange) || errors.Is(err, ErrMinTooShallow) || errors.Is(err, ErrInvalidSettings) {
+		logger.Error("cannot mine", "min", miner.Min, "max", miner.Max, "seeds", miner.HowMany, "err", err)
+		os.Exit(2)
+	}
+	if err == nil && (*resume != "" || *checkpoint > 0) {
+		RemoveCheckpoint(checkpointpath)
+	} else if err != nil && ctx.Err() == nil {
+		logger.Error("mining stopped early; saving the seeds found", "err", err)
+	}
+	realmin, realmax := stats.RealMin, stats.RealMax
+	for _, s := range resumed {
+		if s.Depth < realmin {
+			realmin = s.Depth
+		}
+		if s.Depth > realmax {
+			realmax = s.Depth
+		}
+	}
+	seeds = append(resumed, seeds...)
+	if len(seeds) == 0 {
+		logger.Warn("no seeds found, nothing to save")
+		return
+	}
+	pack, depths := PackSeedsWithDepths(seeds)
+	if *logformat == "json" {
+		if histogram, err := DepthHistogram(depths, *min, *max, 10); err != nil {
+			logger.Warn("cannot count depths", "err", err)
+		} else {
+			logger.Info("depth histogram", "min", *min, "max", *max, "counts", histogram)
+		}
+	} else if err := PrintDepthHistogram(depths, *min, *max, 10); err != nil {
+		logger.Warn("cannot count depths", "err", err)
+	}
+	if *appendpath != "" {
+		lo, hi := RealDepthRange(existing, existingmeta, *bailout**bailout, formula)
+		if lo < realmin {
+			realmin = lo
+		}
+		if hi > realmax {
+			realmax = hi
+		}
+		pack = append(pack, existing...)
+		*out = *appendpath
+	}
+	if *dedup || *appendpath != "" {
+		mined := len(pack)
+		pack = pack.DedupUnsorted()
+		logger.Info("dropped duplicate seeds", "count", mined-len(pack))
+	}
+	if *spacingstats {
+		mean, least := pack.SpacingStats()
+		logger.Info("nearest-neighbor spacing", "seeds", len(pack), "mean", mean, "min", least)
+	}
+
+	var smoothdepths []float64
+	if *smooth {
+		smoothdepths = SmoothDepths(pack, seeds, *bailout**bailout, formula)
+	}
+
+	var seeddepths []int
+	if *storedepths || order == OrderDepth {
+		seeddepths = SeedDepths(pack, seeds, *bailout**bailout, formula)
+	}
+	pack, seeddepths, smoothdepths, err = pack.SortBy(order, seeddepths, smoothdepths)
+	if err != nil {
+		logger.Error("cannot sort seeds", "err", err)
+		os.Exit(1)
+	}
+	if !*storedepths {
+		seeddepths = nil
+	}
+
+	// The preview is only rendered once the seeds are safely saved, so that
+	// failing to render it cannot lose them.
+	savePreview := func() {
+		if *pngpath == "" {
+			return
+		}
+		if err := SavePNGFile(RenderSeeds(pack, 1024, 1024), *pngpath); err != nil {
+			logger.Warn("cannot render seeds", "path", *pngpath, "err", err)
+			return
+		}
+		logger.Info("rendered seeds", "path", *pngpath)
+	}
+
+	if *bins > 1 {
+		depths := seeddepths
+		if depths == nil {
+			depths = SeedDepths(pack, seeds, *bailout**bailout, formula)
+		}
+		for _, band := range DepthBands(*min, *max, *bins) {
+			var banded seedpack
+			var banddepths []int
+			var bandsmooth []float64
+			bandmin, bandmax := band[1], band[0]
+			for idx, c := range pack {
+				if depths[idx] < band[0] || depths[idx] > band[1] {
+					continue
+				}
+				banded = append(banded, c)
+				if seeddepths != nil {
+					banddepths = append(banddepths, seeddepths[idx])
+				}
+				if smoothdepths != nil {
+					bandsmooth = append(bandsmooth, smoothdepths[idx])
+				}
+				if depths[idx] < bandmin {
+					bandmin = depths[idx]
+				}
+				if depths[idx] > bandmax {
+					bandmax = depths[idx]
+				}
+			}
+			if len(banded) == 0 {
+				logger.Warn("no seeds in depth band", "min", band[0], "max", band[1])
+				continue
+			}
+			saved, err := SaveSeedsAs(*format, banded, banddepths, bandsmooth, band[0], band[1], bandmin, bandmax, BandFileName(*out, band[0], band[1]), *gz, *precisionout)
+			if err != nil {
+				logger.Error("cannot save seeds", "min", band[0], "max", band[1], "err", err)
+				os.Exit(1)
+			}
+			logger.Info("saved depth band", "min", band[0], "max", band[1], "seeds", len(banded), "path", saved)
+		}
+		savePreview()
+		return
+	}
+
+	if _, err := SaveSeedsAs(*format, pack, seeddepths, smoothdepths, *min, *max, realmin, realmax, *out, *gz, *precisionout); err != nil {
+		logger.Error("cannot save seeds", "err", err)
+		os.Exit(1)
+	}
+	savePreview()
+}
+
+// SaveSeedsAs saves seeds in format, "ems", "csv" or "json", with the given
+// smooth depths, which may be nil. Depths, if not nil, are only stored in .ems
+// files, which are gzip-compressed if gz is set or filename ends in .gz. The
+// seeds are saved in the order given, as float32 pairs in .ems files if
+// seedbits is 32. It returns the path of the file written.
+func SaveSeedsAs(format string, seeds seedpack, depths []int, smooth []float64, min, max, realmin, realmax int, filename string, gz bool, seedbits int) (string, error) {
+	switch format {
+	case "csv":
+		return SaveCSVFile(seeds, smooth, realmin, realmax, filename, OrderNone)
+	case "json":
+		return SaveJSONFile(seeds, smooth, min, max, realmin, realmax, filename, OrderNone)
+	default:
+		return SaveEMSFile(seeds, depths, smooth, min, max, realmin, realmax, filename, gz || strings.HasSuffix(filename, ".gz"), seedbits, OrderNone)
+	}
+}
+
+// SeedDepths returns the escape depth of every seed in pack, taken from mined
+// where possible and otherwise recomputed for the squared bailout radius b
+// and formula f.
+func SeedDepths(pack seedpack, mined []Seed, b float64, f Formula) []int {
+	known := make(map[complex128]int, len(mined))
+	for _, s := range mined {
+		known[s.C] = s.Depth
+	}
+	depths := make([]int, len(pack))
+	for idx, c := range pack {
+		if depth, ok := known[c]; ok {
+			depths[idx] = depth
+		} else {
+			depths[idx] = SeedDepth(c, MaxSeedDepth, b, f)
+		}
+	}
+	return depths
+}
+
+// SmoothDepths returns the smooth depth of every seed in pack, taken from
+// mined where it was computed while mining and otherwise recomputed for the
+// squared bailout radius b and formula f, as for seeds from a checkpoint or
+// appended file.
+func SmoothDepths(pack seedpack, mined []Seed, b float64, f Formula) []float64 {
+	known := make(map[complex128]float64, len(mined))
+	for _, s := range mined {
+		if s.Smooth != 0 {
+			known[s.C] = s.Smooth
+		}
+	}
+	smooth := make([]float64, len(pack))
+	for idx, c := range pack {
+		if mu, ok := known[c]; ok {
+			smooth[idx] = mu
+		} else {
+			smooth[idx] = SeedSmoothDepth(c, MaxSeedDepth, b, f)
+		}
+	}
+	return smooth
+}
+
+func PrintBanner() {
+	fmt.Println("\nEMSMiner v0.2 Copyright (C) 2020 Daïm Aggott-Hönsch. This program comes with ABSOLUTELY NO WARRANTY.")
+	fmt.Println("This is free software, and you are welcome to redistribute it under the conditions specified by")
+	fmt.Println("the GNU General Public License 3 (https://www.gnu.org/licenses/gpl-3.0).")
+}
+
+// Random number generation
+
+// rng is the source of candidate points for mining, and guiderng the source
+// of sample points for guidemap generation. They are kept apart so that the
+// candidates drawn by Mine do not depend on how many samples the time-bounded
+// guidemap generation happened to consume.
+var rng = rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+var guiderng = rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+
+func SeedRandom(seed int64) {
+	rng = rand.New(rand.NewSource(seed))
+	guiderng = rand.New(rand.NewSource(seed ^ 0x454d53))
+}
+
+// Region is a rectangle of the complex plane.
+type Region struct {
+	MinR, MaxR float64
+	MinI, MaxI float64
+}
+
+// FullRegion covers the whole Mandelbrot set. Both halves of the imaginary
+// axis are included so that the seedpack, realmin/realmax and the guidemap are
+// not biased towards the upper half-plane.
+var FullRegion = Region{-2.00, 2.00, -2.00, 2.00}
+
+// ParseRegion parses a region given as "minR,maxR,minI,maxI".
+func ParseRegion(s string) (Region, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return Region{}, errors.New("region \"" + s + "\" is not of the form minR,maxR,minI,maxI")
+	}
+	var bounds [4]float64
+	for idx, field := range fields {
+		var err error
+		if bounds[idx], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return Region{}, errors.New("region \"" + s + "\" has an invalid bound: " + err.Error())
+		}
+	}
+	region := Region{bounds[0], bounds[1], bounds[2], bounds[3]}
+	if region.MinR >= region.MaxR || region.MinI >= region.MaxI {
+		return Region{}, errors.New("region \"" + s + "\" is empty")
+	}
+	return region, nil
+}
+
+// String formats the region as ParseRegion reads it.
+func (this Region) String() string {
+	bounds := []string{}
+	for _, bound := range []float64{this.MinR, this.MaxR, this.MinI, this.MaxI} {
+		bounds = append(bounds, strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	return strings.Join(bounds, ",")
+}
+
+// ParseTile parses a tile given as "x/N", the x-th of N strips, counting from
+// 1.
+func ParseTile(s string) (int, int, error) {
+	fields := strings.Split(s, "/")
+	if len(fields) != 2 {
+		return 0, 0, errors.New("tile \"" + s + "\" is not of the form x/N")
+	}
+	x, errx := strconv.Atoi(strings.TrimSpace(fields[0]))
+	n, errn := strconv.Atoi(strings.TrimSpace(fields[1]))
+	if errx != nil || errn != nil || n < 1 || x < 1 || x > n {
+		return 0, 0, errors.New("tile \"" + s + "\" is not one of 1/N through N/N")
+	}
+	return x, n, nil
+}
+
+// Strip returns the x-th, counting from 1, of n vertical strips of equal width
+// the region is cut into. Neighbouring strips compute their shared edge alike,
+// so strips 1 through n tile the region without gaps or overlap: the candidates
+// of nodes each sampling their own strip together cover the region as one node
+// sampling all of it would. The strips span the whole imaginary range, so the
+// conjugate of a seed, which -mirror adds, lies in the same strip.
+func (this Region) Strip(x, n int) Region {
+	edge := func(k int) float64 {
+		if k == n {
+			return this.MaxR
+		}
+		return this.MinR + (this.MaxR-this.MinR)*float64(k)/float64(n)
+	}
+	return Region{edge(x - 1), edge(x), this.MinI, this.MaxI}
+}
+
+// Sample draws a point uniformly from the region.
+func (this Region) Sample(r *rand.Rand) complex128 {
+	return complex(this.MinR+r.Float64()*(this.MaxR-this.MinR), this.MinI+r.Float64()*(this.MaxI-this.MinI))
+}
+
+// .EMS file handling
+
+// EMSHeader is the magic string every .ems file starts with.
+const EMSHeader = "@DM.EMS{codex.apeirography.art} "
+
+// EMSVersion is the newest version of the metadata block understood here.
+// Version 2 files end in a CRC32 (IEEE) footer covering every preceding byte.
+// Version 3 files also store the smooth depth of every seed, as a float64
+// following the seeds in the same order. Version 4 files instead follow the
+// seeds with the escape depth of every seed as a uint32, and then optionally
+// with their smooth depths, as the size of the body tells. Version 5 files
+// end their metadata block with EMSFlags saying what the body holds, which
+// lets them store the seeds as float32 pairs. SaveEMSFile only writes version
+// 3, 4 or 5 when it needs to, so plain seedpacks stay readable by older tools.
+const EMSVersion = 5
+
+// The EMSFlags of a version 5 file.
+const (
+	// EMSDepths says the seeds are followed by their depths as uint32s.
+	EMSDepths uint32 = 1 << iota
+
+	// EMSSmooth says the seeds, and depths if any, are followed by the smooth
+	// depths of the seeds as float64s.
+	EMSSmooth
+
+	// EMSFloat32 says the seeds are stored as pairs of float32s rather than
+	// float64s, halving their size at the cost of all but about seven
+	// significant digits. That is plenty to tell shallow seeds apart, but
+	// deep seeds lie so close together and so close to the boundary of the
+	// set that rounding them this much loses them.
+	EMSFloat32
+)
+
+// EMSMetadata is the block stored right after the magic string. It records
+// the requested depth range [Min, Max], the range [RealMin, RealMax] actually
+// found, and the number of seeds; from version 5 on, it also records the
+// EMSFlags describing the body. Old-style files without the block are reported
+// as version 0 with only Count filled in.
+type EMSMetadata struct {
+	Version          uint16
+	Min, Max         int32
+	RealMin, RealMax int32
+	Count            uint64
+	Flags            uint32
+}
+
+// emsMetadataFields is the metadata block following the version field, laid
+// out as in EMSMetadata.
+type emsMetadataFields struct {
+	Min, Max         int32
+	RealMin, RealMax int32
+	Count            uint64
+}
+
+// WriteEMSHeader writes the magic string of an .ems file of the given version
+// to w, followed by the version field opening the metadata block unless the
+// version is 0. Old-style version 0 files begin with the seeds right after the
+// magic string.
+func WriteEMSHeader(w io.Writer, version uint16) error {
+	if _, err := io.WriteString(w, EMSHeader); err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	return binary.Write(w, binary.LittleEndian, version)
+}
+
+// ReadEMSHeader reads the magic string and version field written by
+// WriteEMSHeader for a file of version 1 or later, and checks that this
+// version is understood. An old-style file has no version field, so the bytes
+// read as one would be part of its first seed; ReadEMS tells such files apart
+// by their length before reading the header.
+func ReadEMSHeader(r io.Reader) (uint16, error) {
+	magic := make([]byte, len(EMSHeader))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != EMSHeader {
+		return 0, errors.New("not an .ems file (bad header)")
+	}
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return 0, errors.New("truncated metadata")
+	}
+	if version < 1 || version > EMSVersion {
+		return version, errors.New("unsupported .ems version " + strconv.Itoa(int(version)))
+	}
+	return version, nil
+}
+
+// EMSContents is everything an .ems file stores. Depths and Smooth are nil
+// unless the file holds them, in which case they are aligned with Seeds.
+type EMSContents struct {
+	Meta   EMSMetadata
+	Seeds  seedpack
+	Depths []int
+	Smooth []float64
+}
+
+// SaveEMSFile writes seeds mined for depths [min, max], whose depths actually
+// range over [realmin, realmax], to filename, or to an automatically named file
+// next to the executable if filename is empty. depths and smooth, if not nil,
+// hold the escape and smooth depth of every seed and are stored alongside them.
+// If seedbits is 32, the seeds are stored as float32 pairs (see EMSFloat32)
+// rather than float64 pairs. The seeds are written in the given order; as the
+// MD5 of an automatically named file is taken over the seeds in that order, the
+// same seeds sorted differently get a different name. If gz is set, the file is
+// gzip-compressed and named with an .ems.gz extension.
+//
+// It returns the path of the file written. If the disk is full, the file is
+// written to the temporary directory instead, and that path returned. An
+// existing file is only replaced once the new one is completely written.
+func SaveEMSFile(seeds seedpack, depths []int, smooth []float64, min, max, realmin, realmax int, filename string, gz bool, seedbits int, order SeedOrder) (string, error) {
+	contents, err := emsContents(seeds, depths, smooth, min, max, realmin, realmax, seedbits, order)
+	if err != nil {
+		return "", err
+	}
+
+	ext := ".ems"
+	if gz {
+		ext = ".ems.gz"
+	}
+	outfilename, err := OutputFileName(contents.Seeds, realmin, realmax, filename, ext)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeEMSFile(outfilename, contents, gz)
+	if errors.Is(err, syscall.ENOSPC) {
+		fallback := filepath.Join(os.TempDir(), filepath.Base(outfilename))
+		logger.Warn("disk full; saving to the temporary directory instead", "path", outfilename, "fallback", fallback)
+		if err = writeEMSFile(fallback, contents, gz); err == nil {
+			logger.Warn("saved seeds to the fallback location", "path", fallback)
+			return fallback, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return outfilename, nil
+}
+
+// SaveEMS writes the seeds to w exactly as SaveEMSFile writes them to an
+// uncompressed file, returning the number of bytes written. Given the same
+// seeds it produces the same bytes, so it can be compared against a known
+// good encoding.
+func SaveEMS(w io.Writer, seeds seedpack, depths []int, smooth []float64, min, max, realmin, realmax int, seedbits int, order SeedOrder) (int64, error) {
+	contents, err := emsContents(seeds, depths, smooth, min, max, realmin, realmax, seedbits, order)
+	if err != nil {
+		return 0, err
+	}
+	return WriteEMS(w, contents)
+}
+
+// emsContents sorts the seeds into order and picks the oldest .ems version
+// able to store them with the given depths, smooth depths and precision.
+func emsContents(seeds seedpack, depths []int, smooth []float64, min, max, realmin, realmax int, seedbits int, order SeedOrder) (EMSContents, error) {
+	seeds, depths, smooth, err := seeds.SortBy(order, depths, smooth)
+	if err != nil {
+		return EMSContents{}, err
+	}
+
+	version, flags := uint16(2), uint32(0)
+	if seedbits == 32 {
+		version, flags = 5, EMSFloat32
+	} else if depths != nil {
+		version = 4
+	} else if smooth != nil {
+		version = 3
+	}
+
+	return EMSContents{
+		Meta: EMSMetadata{
+			Version: version,
+			Min:     int32(min),
+			Max:     int32(max),
+			RealMin: int32(realmin),
+			RealMax: int32(realmax),
+			Flags:   flags,
+		},
+		Seeds:  seeds,
+		Depths: depths,
+		Smooth: smooth,
+	}, nil
+}
+
+// writeEMSFile writes contents to a temporary file next to path, gzipped if gz
+// is set, and then renames it to path, so that a failed write leaves any
+// existing file at path untouched.
+func writeEMSFile(path string, contents EMSContents, gz bool) error {
+	outfile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = outfile
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(outfile)
+		w = zw
+	}
+	_, err = WriteEMS(w, contents)
+	if zw != nil {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = outfile.Chmod(0644)
+	}
+	if cerr := outfile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(outfile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(outfile.Name())
+	}
+	return err
+}
+
+// WriteEMS writes contents to w in .ems format, filling in the Count of its
+// metadata and, for version 5, the EMSDepths and EMSSmooth flags. Depths may
+// only be given for versions 4 and 5 and Smooth for versions 3 to 5, and only
+// version 5 honours the EMSFloat32 flag. A version 0 Meta writes an old-style
+// file of just the magic string and the seeds, with no metadata block or
+// checksum.
+func WriteEMS(w io.Writer, contents EMSContents) (int64, error) {
+	meta := contents.Meta
+	if contents.Depths != nil && (meta.Version < 4 || len(contents.Depths) != len(contents.Seeds)) {
+		return 0, errors.New("depths can only be stored aligned with the seeds in a version 4 or 5 .ems file")
+	}
+	if contents.Smooth != nil && (meta.Version < 3 || len(contents.Smooth) != len(contents.Seeds)) {
+		return 0, errors.New("smooth depths can only be stored aligned with the seeds in a version 3 to 5 .ems file")
+	}
+
+	buf := new(bytes.Buffer)
+	WriteEMSHeader(buf, meta.Version)
+	if meta.Version > 0 {
+		binary.Write(buf, binary.LittleEndian, emsMetadataFields{meta.Min, meta.Max, meta.RealMin, meta.RealMax, uint64(len(contents.Seeds))})
+	}
+	narrow := false
+	if meta.Version >= 5 {
+		flags := meta.Flags &^ (EMSDepths | EMSSmooth)
+		if contents.Depths != nil {
+			flags |= EMSDepths
+		}
+		if contents.Smooth != nil {
+			flags |= EMSSmooth
+		}
+		binary.Write(buf, binary.LittleEndian, flags)
+		narrow = flags&EMSFloat32 != 0
+	}
+	for _, c := range contents.Seeds {
+		if narrow {
+			binary.Write(buf, binary.LittleEndian, complex64(c))
+		} else {
+			binary.Write(buf, binary.LittleEndian, c)
+		}
+	}
+	for _, depth := range contents.Depths {
+		binary.Write(buf, binary.LittleEndian, uint32(depth))
+	}
+	for _, mu := range contents.Smooth {
+		binary.Write(buf, binary.LittleEndian, mu)
+	}
+	if meta.Version >= 2 {
+		binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	}
+	return buf.WriteTo(w)
+}
+
+// WriteTo writes the seedpack to w as an old-style .ems file, implementing
+// io.WriterTo. A seedpack does not know the depths of its seeds, so no
+// metadata block is written; use WriteEMS to include one.
+func (this seedpack) WriteTo(w io.Writer) (int64, error) {
+	return WriteEMS(w, EMSContents{Seeds: this})
+}
+
+// SaveCSVFile writes seeds as real,imag lines to filename, or to an
+// automatically named .csv file next to the executable if filename is empty.
+// If smooth is not nil, each line gains the seed's smooth depth as a third
+// column. The seeds are written in the given order, which cannot be by depth.
+// It returns the path of the file written.
+func SaveCSVFile(seeds seedpack, smooth []float64, min, max int, filename string, order SeedOrder) (string, error) {
+	seeds, _, smooth, err := seeds.SortBy(order, nil, smooth)
+	if err != nil {
+		return "", err
+	}
+
+	outfile, err := CreateOutputFile(seeds, min, max, filename, ".csv")
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	for idx, c := range seeds {
+		buf.WriteString(strconv.FormatFloat(real(c), 'g', -1, 64) + "," + strconv.FormatFloat(imag(c), 'g', -1, 64))
+		if smooth != nil {
+			buf.WriteString("," + strconv.FormatFloat(smooth[idx], 'g', -1, 64))
+		}
+		buf.WriteString("\n")
+	}
+	_, err = outfile.Write(buf.Bytes())
+	return closeOutputFile(outfile, err)
+}
+
+// EMSJSON is the document written by SaveJSONFile.
+type EMSJSON struct {
+	Min         int        `json:"min"`
+	Max         int        `json:"max"`
+	RealMin     int        `json:"realmin"`
+	RealMax     int        `json:"realmax"`
+	Count       int        `json:"count"`
+	GeneratedAt string     `json:"generatedAt"`
+	Seeds       []SeedJSON `json:"seeds"`
+}
+
+// SeedJSON is a single seed within an EMSJSON document.
+type SeedJSON struct {
+	R      float64 `json:"r"`
+	I      float64 `json:"i"`
+	Smooth float64 `json:"smooth,omitempty"`
+}
+
+// SaveJSONFile writes seeds together with the requested depth range [min, max]
+// and the realized range [realmin, realmax] as an indented JSON document to
+// filename, or to an automatically named .json file if filename is empty.
+// smooth, if not nil, holds the smooth depth of every seed. The seeds are
+// written in the given order, which cannot be by depth. It returns the path of
+// the file written.
+func SaveJSONFile(seeds seedpack, smooth []float64, min, max, realmin, realmax int, filename string, order SeedOrder) (string, error) {
+	seeds, _, smooth, err := seeds.SortBy(order, nil, smooth)
+	if err != nil {
+		return "", err
+	}
+
+	outfile, err := CreateOutputFile(seeds, realmin, realmax, filename, ".json")
+	if err != nil {
+		return "", err
+	}
+
+	doc := EMSJSON{
+		Min:         min,
+		Max:         max,
+		RealMin:     realmin,
+		RealMax:     realmax,
+		Count:       len(seeds),
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Seeds:       make([]SeedJSON, len(seeds)),
+	}
+	for idx, c := range seeds {
+		doc.Seeds[idx] = SeedJSON{R: real(c), I: imag(c)}
+		if smooth != nil {
+			doc.Seeds[idx].Smooth = smooth[idx]
+		}
+	}
+
+	encoder := json.NewEncoder(outfile)
+	encoder.SetIndent("", "  ")
+	return closeOutputFile(outfile, encoder.Encode(doc))
+}
+
+// CreateOutputFile creates (or truncates) filename, creating its parent
+// directories as needed. If filename is empty, the file is instead named
+// "<min>-<max>_<md5>" plus ext next to the executable, where the MD5 is taken
+// over the little-endian bytes of the seeds in the order they are written. If
+// filename is an existing directory, the automatically named file is placed
+// there.
+func CreateOutputFile(seeds seedpack, min, max int, filename, ext string) (*os.File, error) {
+	outfilename, err := OutputFileName(seeds, min, max, filename, ext)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(outfilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// closeOutputFile closes outfile after writing it failed with err, or
+// succeeded if err is nil, and returns its path and the first error of the
+// two.
+func closeOutputFile(outfile *os.File, err error) (string, error) {
+	if cerr := outfile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return outfile.Name(), nil
+}
+
+// OutputFileName returns the path CreateOutputFile creates, creating its parent
+// directories as needed.
+func OutputFileName(seeds seedpack, min, max int, filename, ext string) (string, error) {
+	dir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
+	if info, err := os.Stat(filename); filename != "" && err == nil && info.IsDir() {
+		dir, filename = filename, ""
+	}
+
+	outfilename := filename
+	if outfilename == "" {
+		buf := new(bytes.Buffer)
+		for _, c := range seeds {
+			binary.Write(buf, binary.LittleEndian, c)
+		}
+		md5 := md5.Sum(buf.Bytes())
+
+		outfilename = filepath.Join(dir, strconv.Itoa(min)+"-"+strconv.Itoa(max)+"_"+fmt.Sprintf("%x", string(md5[:]))+ext)
+	} else if err := os.MkdirAll(filepath.Dir(outfilename), 0755); err != nil {
+		return "", err
+	}
+	return outfilename, nil
+}
+
+// LoadEMSFile reads back the seeds and metadata stored in an .ems file
+// written by SaveEMSFile.
+func LoadEMSFile(path string) (seedpack, EMSMetadata, error) {
+	contents, err := LoadEMSContents(path)
+	return contents.Seeds, contents.Meta, err
+}
+
+// LoadEMSContents is like LoadEMSFile but also returns the depths and smooth
+// depths stored in version 3 and 4 files.
+func LoadEMSContents(path string) (EMSContents, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return EMSContents{}, err
+	}
+	defer file.Close()
+
+	contents, _, err := ReadEMS(file)
+	if err != nil {
+		return contents, errors.New(path + ": " + err.Error())
+	}
+	return contents, nil
+}
+
+// ReadFrom reads an .ems file of any version from r until EOF and returns its
+// seeds along with the number of bytes read.
+func ReadFrom(r io.Reader) (seedpack, int64, error) {
+	contents, n, err := ReadEMS(r)
+	return contents.Seeds, n, err
+}
+
+// ReadEMS reads an .ems file of any version from r until EOF and returns its
+// contents and the number of bytes read. The whole file is needed to tell
+// old-style files from newer ones. Gzip-compressed files are recognized by
+// their magic bytes and decompressed transparently.
+func ReadEMS(r io.Reader) (EMSContents, int64, error) {
+	var contents EMSContents
+	meta := &contents.Meta
+
+	file, err := io.ReadAll(r)
+	n := int64(len(file))
+	if err != nil {
+		return contents, n, err
+	}
+	if len(file) >= 2 && file[0] == 0x1f && file[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(file))
+		if err != nil {
+			return contents, n, err
+		}
+		if file, err = io.ReadAll(zr); err != nil {
+			return contents, n, errors.New("corrupt gzip stream: " + err.Error())
+		}
+	}
+	data := file
+
+	if len(data) < len(EMSHeader) || string(data[:len(EMSHeader)]) != EMSHeader {
+		return contents, n, errors.New("not an .ems file (bad header)")
+	}
+	data = data[len(EMSHeader):]
+
+	// Old-style files follow the magic string directly with seeds, so their
+	// body is a whole number of seeds, whereas the metadata block is not.
+	if len(data)%16 != 0 {
+		r := bytes.NewReader(file)
+		version, err := ReadEMSHeader(r)
+		if err != nil {
+			return contents, n, err
+		}
+		var fields emsMetadataFields
+		if err := binary.Read(r, binary.LittleEndian, &fields); err != nil {
+			return contents, n, errors.New("truncated metadata")
+		}
+		*meta = EMSMetadata{version, fields.Min, fields.Max, fields.RealMin, fields.RealMax, fields.Count, 0}
+		if version >= 5 {
+			if err := binary.Read(r, binary.LittleEndian, &meta.Flags); err != nil {
+				return contents, n, errors.New("truncated metadata")
+			}
+		}
+		data = file[len(file)-r.Len():]
+
+		if meta.Version >= 2 {
+			if len(data) < 4 {
+				return contents, n, errors.New("missing checksum")
+			}
+			footer := len(file) - 4
+			if crc32.ChecksumIEEE(file[:footer]) != binary.LittleEndian.Uint32(file[footer:]) {
+				return contents, n, errors.New("checksum mismatch, the file is corrupt or truncated")
+			}
+			data = data[:len(data)-4]
+		}
+	}
+
+	// Only version 5 files may store float32 seeds.
+	seedsize := uint64(16)
+	if meta.Flags&EMSFloat32 != 0 {
+		seedsize = 8
+	}
+
+	if meta.Version >= 3 {
+		count, size := meta.Count, uint64(len(data))
+		var hasDepths, hasSmooth bool
+		switch meta.Version {
+		case 3:
+			hasSmooth = true
+			if size != count*24 {
+				return contents, n, errors.New("metadata promises " + strconv.FormatUint(count, 10) + " seeds with smooth depths but " + strconv.Itoa(len(data)) + " bytes are stored")
+			}
+		case 4:
+			hasDepths, hasSmooth = true, size == count*28
+			if size != count*20 && size != count*28 {
+				return contents, n, errors.New("metadata promises " + strconv.FormatUint(count, 10) + " seeds with depths but " + strconv.Itoa(len(data)) + " bytes are stored")
+			}
+		default:
+			hasDepths, hasSmooth = meta.Flags&EMSDepths != 0, meta.Flags&EMSSmooth != 0
+			stride := seedsize
+			if hasDepths {
+				stride += 4
+			}
+			if hasSmooth {
+				stride += 8
+			}
+			if size != count*stride {
+				return contents, n, errors.New("metadata promises " + strconv.FormatUint(count, 10) + " seeds of " + strconv.FormatUint(stride, 10) + " bytes each but " + strconv.Itoa(len(data)) + " bytes are stored")
+			}
+		}
+
+		extra := bytes.NewReader(data[count*seedsize:])
+		if hasDepths {
+			depths := make([]uint32, count)
+			if err := binary.Read(extra, binary.LittleEndian, depths); err != nil {
+				return contents, n, err
+			}
+			contents.Depths = make([]int, count)
+			for idx, depth := range depths {
+				contents.Depths[idx] = int(depth)
+			}
+		}
+		if hasSmooth {
+			contents.Smooth = make([]float64, count)
+			if err := binary.Read(extra, binary.LittleEndian, contents.Smooth); err != nil {
+				return contents, n, err
+			}
+		}
+		data = data[:count*seedsize]
+	}
+
+	if uint64(len(data))%seedsize != 0 {
+		return contents, n, errors.New("body of " + strconv.Itoa(len(data)) + " bytes is not a whole number of seeds")
+	}
+
+	seeds := NewSeedpack(len(data) / int(seedsize))
+	if seedsize == 8 {
+		narrow := make([]complex64, len(seeds))
+		if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, narrow); err != nil {
+			return contents, n, err
+		}
+		for idx, c := range narrow {
+			seeds[idx] = complex128(c)
+		}
+	} else if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, seeds); err != nil {
+		return contents, n, err
+	}
+
+	if meta.Version == 0 {
+		meta.Count = uint64(len(seeds))
+	} else if meta.Count != uint64(len(seeds)) {
+		return contents, n, errors.New("metadata promises " + strconv.FormatUint(meta.Count, 10) + " seeds but " + strconv.Itoa(len(seeds)) + " are stored")
+	}
+
+	contents.Seeds = seeds
+	return contents, n, nil
+}
+
+// RealDepthRange returns the depth range actually covered by seeds loaded
+// with meta, recomputing it under formula f for the squared bailout radius b
+// if the file predates the metadata block.
+func RealDepthRange(seeds seedpack, meta EMSMetadata, b float64, f Formula) (int, int) {
+	if meta.Version == 0 {
+		return seeds.DepthRange(b, f)
+	}
+	return int(meta.RealMin), int(meta.RealMax)
+}
+
+// Optimized Mining Function
+
+// Mine mines howmany seeds with depths in [min, max] and returns them along
+// with the shallowest and deepest depth actually found. Invalid arguments
+// mine nothing; MineContext reports why.
+func Mine(howmany, min, max int) (seedpack, int, int) {
+	seeds, realmin, realmax, _ := MineContext(context.Background(), howmany, min, max)
+	return seeds, realmin, realmax
+}
+
+// MineContext is like Mine but stops early once ctx is done, returning the
+// seeds found so far together with ctx.Err().
+func MineContext(ctx context.Context, howmany, min, max int) (seedpack, int, int, error) {
+	stream, errs := NewMiner(howmany, min, max).MineStream(ctx)
+	seeds := make(seedpack, 0, SeedCapacity(howmany))
+	realmin, realmax := max, min
+	for s := range stream {
+		seeds = append(seeds, s.C)
+		if s.Depth < realmin {
+			realmin = s.Depth
+		}
+		if s.Depth > realmax {
+			realmax = s.Depth
+		}
+	}
+	return seeds, realmin, realmax, <-errs
+}
+
+// MineRand is like Mine but draws candidates from r instead of the package
+// random source. A generated guidemap depends on how many points could be
+// sampled in the time allotted, so MineRand mines without one: its result
+// depends on nothing but r, which makes it suitable for reproducible runs and
+// regression tests.
+func MineRand(r *rand.Rand, howmany, min, max int) (seedpack, int, int) {
+	miner := NewMiner(howmany, min, max)
+	miner.Rand = r
+	miner.Guidemap = NewGuidemap(51, 51, FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI, false)
+	miner.Guidemap.Disable()
+	found, stats, _ := miner.MineSeeds(context.Background())
+	return PackSeeds(found), stats.RealMin, stats.RealMax
+}
+
+// MineWithDepths is like Mine but also returns the escape depth of every seed
+// in a slice aligned with the seedpack. Use SortWithDepths to keep the two in
+// step when sorting.
+func MineWithDepths(howmany, min, max int) (seedpack, []int, int, int) {
+	found, stats, _ := NewMiner(howmany, min, max).MineSeeds(context.Background())
+	seeds, depths := PackSeedsWithDepths(found)
+	return seeds, depths, stats.RealMin, stats.RealMax
+}
+
+// Miner holds the configuration of a mining run.
+type Miner struct {
+	// HowMany seeds with depths in [Min, Max] are sought.
+	Min, Max int
+	HowMany  int
+
+	// Threads is the number of worker goroutines searching in parallel.
+	Threads int
+
+	// An orbit has escaped once it leaves the circle of radius Bailout, and is
+	// periodic once it returns to within Tolerance of an earlier point.
+	Bailout   float64
+	Tolerance float64
+
+	// Precision above 53 bits iterates with big.Float mantissas of that many
+	// bits instead of float64.
+	Precision uint
+
+	// Variant and Power select the recurrence iterated, z = z^Power + c or
+	// its Tricorn counterpart; a Power of 0 means 2. The guidemap should have
+	// been generated for the same one. Arbitrary precision only supports
+	// Power 2.
+	Variant Variant
+	Power   int
+
+	// Mirror also accepts the complex conjugate of every seed off the real
+	// axis. The Mandelbrot and Tricorn sets are symmetric about the real axis,
+	// so the conjugate has the same depth; mirrored seeds count towards HowMany.
+	Mirror bool
+
+	// Weighted skips candidates in sparsely hit guidemap cells before
+	// iterating them, keeping a candidate with probability proportional to its
+	// cell's density relative to the mean over marked cells. This biases the
+	// search towards productive regions; by default a cell is either marked or
+	// not.
+	Weighted bool
+
+	// Smooth also computes the smooth depth of every seed found, at the cost
+	// of two logarithms per seed.
+	Smooth bool
+
+	// If CheckpointInterval is positive, Checkpoint is called that often with
+	// the seeds found so far and a fresh seed from Rand for resuming the run.
+	CheckpointInterval time.Duration
+	Checkpoint         func(seeds []Seed, stats Stats, resumeSeed int64)
+
+	// Only seeds c with MinMag <= |c| <= MaxMag are accepted, restricting the
+	// search to an annulus around the origin.
+	MinMag, MaxMag float64
+
+	// MaxCandidates, if positive, gives up once that many candidates have
+	// been drawn, so that a depth range that is all but empty in the region
+	// cannot keep a run going forever. The count is only checked from time
+	// to time, so somewhat more may be drawn before mining stops.
+	MaxCandidates int64
+
+	// Neighbors, if positive, has every seed found followed by up to that many
+	// more seeds within NeighborRadius of it, found by probing random points
+	// around it. They count towards HowMany, and yield clusters of closely
+	// related seeds rather than seeds spread evenly over the region.
+	Neighbors      int
+	NeighborRadius float64
+
+	// MinSeparation, if positive, rejects seeds closer than that to a seed
+	// already accepted in this run, spreading the seeds out more evenly than
+	// the guidemap alone would.
+	MinSeparation float64
+
+	// MaxDistance, if positive, rejects seeds whose estimated distance to the
+	// boundary of the set, as given by SeedDistance, exceeds it. It cannot be
+	// combined with arbitrary-precision iteration.
+	MaxDistance float64
+
+	// Region is the rectangle candidates are drawn from, and Sampler how they
+	// are drawn from it.
+	Region  Region
+	Sampler Sampler
+
+	// Guidemap steers the search towards productive cells. Every worker marks
+	// the seeds it finds in a copy of its own, and the copies are merged back
+	// into Guidemap when mining stops; mirrored seeds are marked in Guidemap
+	// directly. If nil, a 51x51 guidemap is generated for 60 seconds when
+	// mining starts.
+	Guidemap *Guidemap
+
+	// Rand is the source of candidate points. Each worker draws from its own
+	// source seeded from Rand and the seeds are taken from the workers in
+	// turn, so a run is reproducible for a given Rand and deterministic
+	// guidemap, whatever the number of Threads; only the candidate count
+	// varies, by the few drawn while the workers are being stopped.
+	Rand *rand.Rand
+
+	// Progress is reported every ProgressInterval, as prose on stdout if
+	// "text", or as ProgressJSON lines on stderr if "json". A ProgressInterval
+	// of zero means DefaultProgressInterval.
+	Progress         string
+	ProgressInterval time.Duration
+
+	// ProgressFunc, if not nil, is called with the statistics so far instead
+	// of reporting progress as Progress says. It is called from the goroutine
+	// running MineSeeds.
+	ProgressFunc func(stats Stats)
+}
+
+// Stats summarizes a mining run. Found/Candidates is the acceptance ratio:
+// the fraction of the candidates drawn that turned out to be seeds. Centroid
+// is the mean of the seeds found, where they are concentrated, or 0 if none
+// were.
+type Stats struct {
+	Found            int
+	Candidates       int
+	RealMin, RealMax int
+	Elapsed          time.Duration
+	SeedsPerHour     float64
+	Centroid         complex128
+}
+
+// NewMiner returns a single-threaded Miner for howmany seeds with depths in
+// [min, max], with the default bailout and periodicity tolerance and drawing
+// candidates from the package random source.
+func NewMiner(howmany, min, max int) *Miner {
+	return &Miner{
+		Min:              min,
+		Max:              max,
+		HowMany:          howmany,
+		Threads:          1,
+		Bailout:          2.00,
+		Tolerance:        DefaultPeriodTolerance,
+		MaxMag:           math.Inf(1),
+		Region:           FullRegion,
+		Sampler:          RandomSampler{},
+		Rand:             rng,
+		Progress:         "text",
+		ProgressInterval: DefaultProgressInterval,
+	}
+}
+
+// Run mines the configured seeds. If mining is cut short, the seeds found so
+// far are returned along with the error.
+func (this *Miner) Run() (seedpack, Stats, error) {
+	found, stats, err := this.MineSeeds(context.Background())
+	return PackSeeds(found), stats, err
+}
+
+// MineSeeds is like Run but also reports the escape depth of every seed, and
+// stops early once ctx is done, returning the seeds found so far together
+// with ctx.Err(). A miner whose settings are invalid mines nothing and returns
+// the error from Validate.
+func (this *Miner) MineSeeds(ctx context.Context) ([]Seed, Stats, error) {
+	seeds := make([]Seed, 0, SeedCapacity(this.HowMany))
+	stats, err := this.mine(ctx, func(s Seed) bool {
+		seeds = append(seeds, s)
+		return true
+	}, func() []Seed {
+		return seeds
+	})
+	return seeds, stats, err
+}
+
+// SeedCapacityHint is the most seeds room is made for before mining starts.
+// Beyond it, seeds are collected in a slice that grows as they are found, so
+// that a huge -howmany that is never reached does not claim memory for
+// seeds never found.
+const SeedCapacityHint = 1 << 16
+
+// SeedCapacity returns the initial capacity of a slice to collect howmany
+// seeds in.
+func SeedCapacity(howmany int) int {
+	if howmany < 0 {
+		return 0
+	}
+	if howmany > SeedCapacityHint {
+		return SeedCapacityHint
+	}
+	return howmany
+}
+
+// MineStream is like MineSeeds but hands every seed to the caller on the
+// returned channel as soon as it is accepted, instead of collecting them all.
+// Mining waits while the caller is not receiving. The seed channel is closed
+// once mining stops, after which the error channel yields what MineSeeds
+// would have returned as its error. Checkpoints are not taken, as the seeds
+// found so far are the caller's.
+func (this *Miner) MineStream(ctx context.Context) (<-chan Seed, <-chan error) {
+	seeds := make(chan Seed)
+	errs := make(chan error, 1)
+	if err := this.Validate(); err != nil {
+		close(seeds)
+		errs <- err
+		close(errs)
+		return seeds, errs
+	}
+	go func() {
+		defer close(seeds)
+		_, err := this.mine(ctx, func(s Seed) bool {
+			select {
+			case seeds <- s:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}, nil)
+		errs <- err
+		close(errs)
+	}()
+	return seeds, errs
+}
+
+// The errors Validate reports for a Miner that cannot mine. Errors about
+// settings other than the seed count and depth range wrap ErrInvalidSettings.
+var (
+	ErrInvalidCount    = errors.New("number of seeds sought is less than one")
+	ErrInvalidRange    = errors.New("maximum seed depth is less than minimum seed depth")
+	ErrMinTooShallow   = errors.New("minimum seed depth is less than 2")
+	ErrInvalidSettings = errors.New("invalid miner settings")
+)
+
+// Validate checks the settings of the miner, returning the error MineSeeds
+// would fail with, or nil if it can mine.
+func (this *Miner) Validate() error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSettings, reason)
+	}
+
+	switch {
+	case this.HowMany < 1:
+		return ErrInvalidCount
+	case this.Max < this.Min:
+		return ErrInvalidRange
+	case this.Min < 2:
+		return ErrMinTooShallow
+	case this.Bailout < 2:
+		return invalid("bailout radius is less than 2")
+	case this.Tolerance < 0:
+		return invalid("periodicity tolerance is negative")
+	case this.Progress != "text" && this.Progress != "json":
+		return invalid("progress format is neither text nor json")
+	case this.Threads < 1:
+		return invalid("number of threads is less than one")
+	case this.Rand == nil:
+		return invalid("random source is nil")
+	case !(this.Region.MinR < this.Region.MaxR && this.Region.MinI < this.Region.MaxI):
+		return invalid("region is empty")
+	case this.MinMag < 0 || this.MaxMag < this.MinMag:
+		return invalid("magnitude range is empty")
+	case this.MinSeparation < 0:
+		return invalid("minimum seed separation is negative")
+	case this.Neighbors < 0 || (this.Neighbors > 0 && this.NeighborRadius <= 0):
+		return invalid("neighbor count is negative or neighbor radius is not positive")
+	case this.MaxDistance < 0 || (this.MaxDistance > 0 && this.Precision > 53):
+		return invalid("maximum distance is negative or combined with arbitrary precision")
+	case this.Power != 0 && this.Power < 2:
+		return invalid("power is less than 2")
+	case this.Power != 0 && this.Power != 2 && this.Precision > 53:
+		return invalid("arbitrary-precision iteration only supports power 2")
+	}
+	return nil
+}
+
+// mine runs the mining loop behind MineSeeds and MineStream, passing every
+// seed accepted to emit, which reports false if the seed could not be taken
+// because ctx is done. sofar, if not nil, returns the seeds emitted so far
+// for checkpoints.
+func (this *Miner) mine(ctx context.Context, emit func(Seed) bool, sofar func() []Seed) (Stats, error) {
+
+	howmany, min, max := this.HowMany, this.Min, this.Max
+	bailout, tolerance := this.Bailout, this.Tolerance
+	precision, threads := this.Precision, this.Threads
+	progress := this.Progress
+
+	/**** Initialization ****/
+
+	if err := this.Validate(); err != nil {
+		return Stats{}, err
+	}
+
+	formula := Formula{this.Variant, this.Power}
+	if formula.Power == 0 {
+		formula.Power = 2
+	}
+
+	sampler := this.Sampler
+	if sampler == nil {
+		sampler = RandomSampler{}
+	}
+
+	guidemap := this.Guidemap
+	if guidemap == nil {
+		var err error
+		if guidemap, err = GenerateGuidemap(51, 60); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	found := 0
+
+	realmin, realmax := max, min
+
+	startTime := time.Now()
+	logger.Info("commencing mining", "seeds", howmany, "min", min, "max", max, "threads", threads)
+
+	mean := 0.0
+	if this.Weighted {
+		mean = guidemap.MeanDensity()
+	}
+
+	// Every worker sends its seeds down a channel of its own, and the seeds
+	// are taken from the workers in turn, so that which seeds are accepted
+	// depends only on what each worker finds and not on which worker happens
+	// to find its next seed first. A run is then reproducible for a given Rand
+	// whatever the number of threads, as long as the sampler's streams are.
+	var workers sync.WaitGroup
+	var candidates atomic.Int64
+	results := make([]chan Seed, threads)
+	done := make(chan struct{})
+
+	// Each worker checks and marks its own copy of the guidemap, so that the
+	// workers never contend for it; the copies are merged back at the end.
+	locals := make([]*Guidemap, threads)
+	for t := 0; t < threads; t++ {
+		locals[t] = guidemap.Clone()
+	}
+
+	for t := 0; t < threads; t++ {
+		r := rand.New(rand.NewSource(this.Rand.Int63()))
+		sample := sampler.Stream(this.Region, r)
+		results[t] = make(chan Seed, 64)
+		workers.Add(1)
+		go func(r *rand.Rand, sample func() complex128, guidemap *Guidemap, results chan<- Seed) {
+			defer workers.Done()
+			if precision > 53 {
+				mineWorkerBig(r, sample, min, max, bailout*bailout, tolerance*tolerance, mean, precision, formula.Variant, this.Smooth, this.Neighbors, this.NeighborRadius, guidemap, &candidates, results, done)
+			} else {
+				mineWorker(r, sample, min, max, bailout*bailout, tolerance*tolerance, mean, formula, this.Smooth, this.MaxDistance, this.Neighbors, this.NeighborRadius, guidemap, &candidates, results, done)
+			}
+		}(r, sample, locals[t], results[t])
+	}
+
+	interval := this.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	encoder := json.NewEncoder(os.Stderr)
+
+	var checkpoints <-chan time.Time
+	if this.CheckpointInterval > 0 && this.Checkpoint != nil && sofar != nil {
+		ticker := time.NewTicker(this.CheckpointInterval)
+		defer ticker.Stop()
+		checkpoints = ticker.C
+	}
+
+	// The candidate count is checked against MaxCandidates whenever a seed
+	// comes in, and often enough in between that a fruitless search stops
+	// soon after reaching it.
+	var limitchecks <-chan time.Time
+	if this.MaxCandidates > 0 {
+		ticker := time.NewTicker(MaxCandidatesCheckInterval)
+		defer ticker.Stop()
+		limitchecks = ticker.C
+	}
+
+	var sum complex128
+	centroid := func() complex128 {
+		if found == 0 {
+			return 0
+		}
+		return sum / complex(float64(found), 0)
+	}
+
+	snapshot := func() Stats {
+		return Stats{
+			Found:        found,
+			Candidates:   int(candidates.Load()),
+			RealMin:      realmin,
+			RealMax:      realmax,
+			Elapsed:      time.Since(startTime),
+			SeedsPerHour: float64(found) / elapsedSeconds(startTime) * 60 * 60,
+			Centroid:     centroid(),
+		}
+	}
+
+	var spacing *separationHash
+	if this.MinSeparation > 0 {
+		spacing = newSeparationHash(this.MinSeparation)
+	}
+
+	interrupted, exhausted := false, false
+	next := 0
+	for found < howmany && !interrupted {
+		if this.MaxCandidates > 0 && candidates.Load() >= this.MaxCandidates {
+			exhausted = true
+			break
+		}
+
+		var s Seed
+		select {
+		case s = <-results[next]:
+			next = (next + 1) % threads
+		case <-ticker.C:
+			sps := float64(found) / elapsedSeconds(startTime)
+			if this.ProgressFunc != nil {
+				this.ProgressFunc(snapshot())
+			} else if progress == "json" {
+				encoder.Encode(ProgressJSON{
+					Found:          found,
+					Target:         howmany,
+					ElapsedSeconds: time.Since(startTime).Seconds(),
+					SeedsPerHour:   sps * 60 * 60,
+					EtaSeconds:     (float64(howmany) - float64(found)) / math.Max(sps, 1e-9),
+				})
+			} else if found > 0 {
+				logger.Info("progress", "found", found, "target", howmany, "min", min, "max", max, "left", FormatHMS(int((float64(howmany)-float64(found))/sps)), "sph", int(sps*60*60))
+			}
+			continue
+		case <-limitchecks:
+			continue
+		case <-checkpoints:
+			this.Checkpoint(sofar(), snapshot(), this.Rand.Int63())
+			continue
+		case <-ctx.Done():
+			interrupted = true
+			continue
+		}
+		if mag := cmplx.Abs(s.C); mag < this.MinMag || mag > this.MaxMag {
+			continue
+		}
+		if spacing != nil {
+			if spacing.Crowded(s.C) {
+				continue
+			}
+			spacing.Add(s.C)
+		}
+		i := s.Depth
+		if i < realmin {
+			realmin = i
+		}
+		if i > realmax {
+			realmax = i
+		}
+		if !emit(s) {
+			interrupted = true
+			continue
+		}
+		found++
+		sum += s.C
+		if this.Mirror && imag(s.C) != 0 && found < howmany && (spacing == nil || !spacing.Crowded(cmplx.Conj(s.C))) {
+			mirrored := Seed{C: cmplx.Conj(s.C), Depth: s.Depth, Smooth: s.Smooth}
+			if !emit(mirrored) {
+				interrupted = true
+				continue
+			}
+			found++
+			sum += mirrored.C
+			guidemap.Mark(mirrored.C)
+			if spacing != nil {
+				spacing.Add(mirrored.C)
+			}
+		}
+	}
+
+	close(done)
+	workers.Wait()
+	var mergeerr error
+	for _, local := range locals {
+		if err := guidemap.Merge(local); err != nil && mergeerr == nil {
+			mergeerr = err
+		}
+	}
+
+	elapsed := elapsedSeconds(startTime)
+	sps := float64(found) / elapsed
+	examined := int(candidates.Load())
+
+	summary := "mining finished"
+	if interrupted && ctx.Err() == context.DeadlineExceeded {
+		summary = "time limit reached"
+	} else if interrupted {
+		summary = "mining interrupted"
+	} else if exhausted {
+		summary = "candidate limit reached"
+	}
+	accepted := 0.0
+	if examined > 0 {
+		accepted = float64(found) * 100 / float64(examined)
+	}
+	logger.Info(summary, "found", found, "target", howmany, "min", min, "max", max, "elapsed", FormatHMS(int(math.Floor(elapsed))), "sph", int(sps*60*60), "candidates", examined, "acceptedpercent", accepted, "centroidr", real(centroid()), "centroidi", imag(centroid()))
+
+	stats := Stats{
+		Found:        found,
+		Candidates:   examined,
+		RealMin:      realmin,
+		RealMax:      realmax,
+		Elapsed:      time.Since(startTime),
+		SeedsPerHour: sps * 60 * 60,
+		Centroid:     centroid(),
+	}
+	if mergeerr != nil {
+		return stats, mergeerr
+	}
+	if interrupted {
+		return stats, ctx.Err()
+	}
+	if exhausted {
+		return stats, errors.New("depth range " + strconv.Itoa(min) + " - " + strconv.Itoa(max) + " appears unreachable after " + strconv.Itoa(examined) + " candidates")
+	}
+	return stats, nil
+}
+
+// MaxCandidatesCheckInterval is how often MineSeeds checks the candidate
+// count against Miner.MaxCandidates while no seeds come in.
+const MaxCandidatesCheckInterval = 50 * time.Millisecond
+
+// DefaultProgressInterval is how often MineSeeds reports progress unless told
+// otherwise.
+const DefaultProgressInterval = 2 * time.Second
+
+// ProgressJSON is a progress report emitted by MineSeeds in json mode.
+type ProgressJSON struct {
+	Found          int     `json:"found"`
+	Target         int     `json:"target"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	SeedsPerHour   float64 `json:"seedsPerHour"`
+	EtaSeconds     float64 `json:"etaSeconds"`
+}
+
+// mineWorker draws candidates from sample and sends every one whose depth lies
+// in [min, max] to results, until done is closed. b is the squared bailout
+// radius, t the squared periodicity tolerance and f the formula iterated. If
+// mean is positive, candidates are skipped outright with a probability that
+// falls as the density of their guidemap cell rises towards mean. Every
+// candidate drawn is counted in candidates, in batches to keep the workers from
+// contending. If smooth is set, the smooth depth of every seed sent is computed
+// as well. If maxDistance is positive, seeds whose SeedDistance exceeds it are
+// dropped. After every seed drawn from sample, up to neighbors points within
+// radius of it are sent too, out of at most NeighborProbes times as many
+// probed. Every seed sent is also marked in guidemap, which must not be shared
+// with other workers.
+func mineWorker(r *rand.Rand, sample func() complex128, min, max int, b, t, mean float64, f Formula, smooth bool, maxDistance float64, neighbors int, radius float64, guidemap *Guidemap, candidates *atomic.Int64, results chan<- Seed, done <-chan struct{}) {
+	send := func(c complex128, i int, z complex128) bool {
+		s := Seed{C: c, Depth: i}
+		if smooth {
+			s.Smooth = SmoothDepth(i, z, f.Power)
+		}
+		guidemap.Mark(c)
+		select {
+		case results <- s:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	// The distance estimate iterates a seed a second time, but only seeds in
+	// the depth range, which are few, so the hot loop is left alone.
+	near := func(c complex128, i int) bool {
+		return maxDistance <= 0 || SeedDistance(c, i, b, f) <= maxDistance
+	}
+
+	for j := 1; ; j++ {
+		c := sample()
+
+		if mean <= 0 || keepWeighted(r, c, mean, guidemap) {
+			if i, z := escapeDepth(c, max, b, t, f, guidemap); i >= min && i <= max && near(c, i) {
+				if !send(c, i, z) {
+					candidates.Add(int64(j % 1024))
+					return
+				}
+				probes, kept := 0, 0
+				for ; probes < neighbors*NeighborProbes && kept < neighbors; probes++ {
+					n := c + Perturbation(r, radius)
+					if i, z := escapeDepth(n, max, b, t, f, guidemap); i >= min && i <= max && near(n, i) {
+						kept++
+						if !send(n, i, z) {
+							candidates.Add(int64(j%1024 + probes + 1))
+							return
+						}
+					}
+				}
+				candidates.Add(int64(probes))
+			}
+		}
+
+		if j%1024 == 0 {
+			candidates.Add(1024)
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// keepWeighted reports whether a Weighted worker iterates c: only if guidemap
+// accepts it by Check, which honours -guide-dilate, and then with probability
+// proportional to the density of its cell relative to mean. A cell accepted
+// only for bordering a marked one counts as a single hit.
+func keepWeighted(r *rand.Rand, c complex128, mean float64, guidemap *Guidemap) bool {
+	return guidemap.Check(c) && r.Float64()*mean < math.Max(1, float64(guidemap.Density(c)))
+}
+
+// escapeDepth iterates c for at most max+2 iterations and returns the
+// iteration at which its orbit under formula f leaves the circle whose squared
+// radius is b, together with the point z it escaped to. It returns -1 for
+// points found not to be worth iterating further: those in the main cardioid
+// or period-2 bulb of the quadratic Mandelbrot set, those whose orbit returns
+// to within the squared tolerance t of an earlier point, and, if g is not nil,
+// those lying in a guidemap cell that has never been marked.
+//
+// Periodicity is checked Brent-style: z is remembered at steadily lengthening
+// intervals, and an orbit that comes back to within the tolerance of the
+// remembered point is taken to have settled into a cycle and never to escape.
+func escapeDepth(c complex128, max int, b, t float64, f Formula, g *Guidemap) (int, complex128) {
+	if f.SkipsInterior(c) {
+		return -1, 0
+	}
+
+	var z, oldz complex128
+	l := max + 2
+	repcheckstart := 2
+	repcheck := repcheckstart
+
+	for i := 0; ; {
+		if f.Variant == Tricorn {
+			z = complex(real(z), -imag(z))
+		}
+		if f.Power == 2 {
+			z = z*z + c
+		} else {
+			z = f.pow(z) + c
+		}
+		if repcheck == 0 {
+			if (real(z)-real(oldz))*(real(z)-real(oldz))+(imag(z)-imag(oldz))*(imag(z)-imag(oldz)) <= t {
+				return -1, z
+			}
+			oldz = z
+			if i%8 == 0 {
+				repcheckstart = repcheckstart + 2
+				if i%64 != 0 && g != nil && !g.Check(c) {
+					return -1, z
+				}
+			} else {
+				repcheckstart = repcheckstart + 1
+			}
+			repcheck = repcheckstart
+		}
+		repcheck--
+
+		i++
+		if i >= l || (real(z)*real(z))+(imag(z)*imag(z)) > b {
+			return i, z
+		}
+	}
+}
+
+// CheckInMainCardioidOrBulb reports whether c lies in the main cardioid or the
+// period-2 bulb of the Mandelbrot set. Such points never escape, so they can
+// be rejected without iterating at all.
+func CheckInMainCardioidOrBulb(c complex128) bool {
+	x, y := real(c), imag(c)
+	q := (x-0.25)*(x-0.25) + y*y
+	if q*(q+(x-0.25)) <= 0.25*y*y {
+		return true
+	}
+	return (x+1)*(x+1)+y*y <= 1.00/16.00
+}
+
+// DefaultPeriodTolerance is the distance within which an orbit returning to
+// an earlier point is considered periodic. It is small enough that orbits
+// which merely pass close to an earlier point on their way out are not
+// mistaken for cycles, yet large enough that orbits converging on an attracting
+// cycle are caught long before they would match it bit for bit.
+const DefaultPeriodTolerance = 1e-12
+
+// MaxSeedDepth bounds the iterations SeedDepth spends on seeds of unknown
+// depth, such as those read back from .ems files.
+const MaxSeedDepth = 1 << 24
+
+// SeedDepth returns the iteration at which the orbit of c under formula f
+// leaves the circle whose squared radius is b, or -1 if it has not escaped
+// after limit iterations. This is the depth Mine assigns to the seeds it
+// accepts.
+func SeedDepth(c complex128, limit int, b float64, f Formula) int {
+	z := complex(0, 0)
+	for i := 1; i <= limit; i++ {
+		z = f.Step(z, c)
+		if real(z)*real(z)+imag(z)*imag(z) > b {
+			return i
+		}
+	}
+	return -1
+}
+
+// SmoothDepth returns the normalized iteration count i + 1 - log_p(log|z|) of
+// an orbit of power p that escaped to z at depth i. Unlike the depth itself it
+// varies continuously with c, so colouring by it avoids banding.
+func SmoothDepth(i int, z complex128, p int) float64 {
+	if p == 2 {
+		return float64(i) + 1 - math.Log2(math.Log(cmplx.Abs(z)))
+	}
+	return float64(i) + 1 - math.Log(math.Log(cmplx.Abs(z)))/math.Log(float64(p))
+}
+
+// SeedSmoothDepth is like SeedDepth but returns the smooth depth of c, or -1 if
+// it does not escape within limit iterations.
+func SeedSmoothDepth(c complex128, limit int, b float64, f Formula) float64 {
+	z := complex(0, 0)
+	for i := 1; i <= limit; i++ {
+		z = f.Step(z, c)
+		if real(z)*real(z)+imag(z)*imag(z) > b {
+			return SmoothDepth(i, z, f.Power)
+		}
+	}
+	return -1
+}
+
+// EvaluateCandidates reads candidate points from in, one "r,i" per line, and
+// writes each back to out as "r,i,depth,accepted", with its escape depth as
+// mining computes it for the squared bailout radius b and squared period
+// tolerance t, or -1 if it is interior or has not escaped after max+1
+// iterations, and whether that depth lies within [min, max]. It returns the accepted points with their depths and the number
+// of malformed lines, which are skipped with a warning; blank lines are
+// skipped silently.
+func EvaluateCandidates(in io.Reader, out io.Writer, min, max int, b, t float64, f Formula) (seedpack, []int, int, error) {
+	var accepted seedpack
+	var depths []int
+	invalid := 0
+	scanner := bufio.NewScanner(in)
+	writer := bufio.NewWriter(out)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		var r, i float64
+		var err error
+		if len(fields) != 2 {
+			err = errors.New("expected \"r,i\"")
+		} else if r, err = strconv.ParseFloat(strings.TrimSpace(fields[0]), 64); err == nil {
+			i, err = strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		}
+		if err != nil {
+			logger.Warn("skipping malformed candidate", "line", line, "text", text, "err", err)
+			invalid++
+			continue
+		}
+
+		c := complex(r, i)
+		depth, _ := escapeDepth(c, max, b, t, f, nil)
+		if depth > max+1 {
+			// escapeDepth gives up at max+2 iterations without the orbit
+			// having escaped.
+			depth = -1
+		}
+		inside := depth >= min && depth <= max
+		if inside {
+			accepted = append(accepted, c)
+			depths = append(depths, depth)
+		}
+		fmt.Fprintln(writer, strconv.FormatFloat(r, 'g', -1, 64)+","+strconv.FormatFloat(i, 'g', -1, 64)+","+strconv.Itoa(depth)+","+strconv.FormatBool(inside))
+	}
+	if err := scanner.Err(); err != nil {
+		return accepted, depths, invalid, err
+	}
+	return accepted, depths, invalid, writer.Flush()
+}
+
+// SeedDistance estimates the distance from c to the boundary of the set of f
+// as |z| log |z| / |dz|, where z is the first point of the orbit of c outside
+// the squared bailout radius b and dz its derivative with respect to c. The
+// estimate is only good to within a small factor, and better for larger
+// bailouts. A c that does not escape within limit iterations is given a
+// distance of 0.
+func SeedDistance(c complex128, limit int, b float64, f Formula) float64 {
+	z, dz := complex(0, 0), complex(0, 0)
+	for i := 1; i <= limit; i++ {
+		z, dz = f.Step(z, c), f.Derivative(z, dz)
+		if real(z)*real(z)+imag(z)*imag(z) > b {
+			r := cmplx.Abs(z)
+			return r * math.Log(r) / cmplx.Abs(dz)
+		}
+	}
+	return 0
+}
+
+// ShallowDepth is the maximum depth below which mining skips the guidemap
+// unless told otherwise: seeds that shallow are accepted so often that
+// generating a guidemap cannot pay for itself.
+const ShallowDepth = 30
+
+// DryRunCalibration is how long -dry-run mines before extrapolating.
+const DryRunCalibration = 15 * time.Second
+
+// PreflightCandidates is how many candidates -validate-range draws looking
+// for a single seed. It is meant to catch ranges that are out of reach, such
+// as one below the shallowest depth of the region, not to prove that a rare
+// range can be filled, so a few thousand are enough.
+const PreflightCandidates = 5000
+
+// ReachesRange mines a copy of the miner for a single seed, giving up after
+// the given number of candidates, and reports whether it found one. Like a
+// -dry-run calibration it mines for real, but it only asks whether the depth
+// range can be reached at all. It draws from random sources and sampler
+// streams of its own and leaves the guidemap of the miner untouched, so a
+// seeded run finds the same seeds with or without it.
+func (this Miner) ReachesRange(ctx context.Context, candidates int64) bool {
+	this.Rand = rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+	this.Sampler = detachedSampler(this.Sampler)
+	this.HowMany = 1
+	this.MaxCandidates = candidates
+	this.Mirror, this.Neighbors = false, 0
+	this.Checkpoint = nil
+	this.ProgressFunc = func(Stats) {}
+	if this.Guidemap != nil {
+		this.Guidemap = this.Guidemap.Clone()
+	}
+	_, stats, _ := this.MineSeeds(ctx)
+	return stats.Found > 0
+}
+
+// FormatHMS formats a number of seconds the way progress reports show them.
+func FormatHMS(totalseconds int) string {
+	hours := totalseconds / 3600
+	minutes := (totalseconds - (hours * 3600)) / 60
+	seconds := totalseconds - (hours * 3600) - (minutes * 60)
+	return strconv.Itoa(hours) + "h " + strconv.Itoa(minutes) + "m " + strconv.Itoa(seconds) + "s"
+}
+
+// elapsedSeconds returns the time since start in seconds, floored at a
+// millisecond so that rates derived from it stay finite on very short runs.
+func elapsedSeconds(start time.Time) float64 {
+	return math.Max(time.Since(start).Seconds(), 0.001)
+}
+
+// Seedpack
+
+// Seed is a mined point together with the iteration at which it escaped.
+type Seed struct {
+	C     complex128
+	Depth int
+
+	// Smooth is the fractional escape depth of C, or 0 if it was not
+	// computed. See SmoothDepth.
+	Smooth float64
+}
+
+type seedpack []complex128
+
+func NewSeedpack(howmany int) seedpack {
+	return seedpack(make([]complex128, howmany))
+}
+
+// PackSeeds drops the depths from seeds, keeping only their points.
+func PackSeeds(seeds []Seed) seedpack {
+	pack := NewSeedpack(len(seeds))
+	for idx, s := range seeds {
+		pack[idx] = s.C
+	}
+	return pack
+}
+
+// PackSeedsWithDepths splits seeds into a seedpack and an aligned slice of
+// depths.
+func PackSeedsWithDepths(seeds []Seed) (seedpack, []int) {
+	pack := NewSeedpack(len(seeds))
+	depths := make([]int, len(seeds))
+	for idx, s := range seeds {
+		pack[idx] = s.C
+		depths[idx] = s.Depth
+	}
+	return pack, depths
+}
+
+func (this seedpack) Sort() seedpack {
+	sort.SliceStable(this, func(i, j int) bool {
+		if real(this[i]) != real(this[j]) {
+			return real(this[i]) < real(this[j])
+		} else {
+			return imag(this[i]) < imag(this[j])
+		}
+	})
+	return this
+}
 
-	fmt.Println("\nUsage: " + filepath.Base(os.Args[0]) + " -min [minimum_depth] -max [maximum_depth] -howmany [number_of_seeds_wanted]")
+// errDepthsNotAligned is returned when sorting seeds along with depths or
+// smooth depths that do not hold one entry per seed.
+var errDepthsNotAligned = errors.New("depths are not aligned with the seeds")
 
-	fmt.Println("")
-	rand.Seed(time.Now().UTC().UnixNano())
-	seeds, realmin, realmax := Mine(*howmany, *min, *max)
-	SaveEMSFile(seeds, realmin, realmax)
+// SortWithDepths sorts the seedpack like Sort, applying the same permutation
+// to the aligned depths.
+func (this seedpack) SortWithDepths(depths []int) (seedpack, []int, error) {
+	if len(depths) != len(this) {
+		return this, depths, errDepthsNotAligned
+	}
+	sort.Stable(seedsAndDepths{this, depths, nil})
+	return this, depths, nil
 }
 
-// .EMS file handling
+// SortAligned sorts the seedpack like Sort, applying the same permutation to
+// the aligned depths and smooth depths, either of which may be nil.
+func (this seedpack) SortAligned(depths []int, smooth []float64) (seedpack, []int, []float64, error) {
+	if (depths != nil && len(depths) != len(this)) || (smooth != nil && len(smooth) != len(this)) {
+		return this, depths, smooth, errDepthsNotAligned
+	}
+	sort.Stable(seedsAndDepths{this, depths, smooth})
+	return this, depths, smooth, nil
+}
 
-func SaveEMSFile(seeds seedpack, min, max int) {
-	buf := new(bytes.Buffer)
-	buf.Reset()
+// SeedOrder is the order in which seeds are written out.
+type SeedOrder int
 
-	seeds = seeds.Sort()
+const (
+	// OrderLex sorts by real part, then by imaginary part. It is the order
+	// Sort uses and the default one.
+	OrderLex SeedOrder = iota
 
-	for _, c := range seeds {
-		binary.Write(buf, binary.LittleEndian, c)
-	}
+	// OrderDepth sorts by escape depth, shallowest first, breaking ties in
+	// lexicographic order. It needs the depth of every seed.
+	OrderDepth
 
-	md5 := md5.Sum(buf.Bytes())
+	// OrderRadius sorts by distance from the origin, nearest first, breaking
+	// ties in lexicographic order.
+	OrderRadius
 
-	dir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
-	outfilename := filepath.Join(dir, strconv.Itoa(min)+"-"+strconv.Itoa(max)+"_"+fmt.Sprintf("%x", string(md5[:]))+".ems")
-	outfile, err := os.OpenFile(outfilename, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		panic(err)
+	// OrderNone keeps the seeds in the order given.
+	OrderNone
+)
+
+// ParseSeedOrder returns the order called name: "lex", "depth", "radius" or
+// "none".
+func ParseSeedOrder(name string) (SeedOrder, error) {
+	switch name {
+	case "lex":
+		return OrderLex, nil
+	case "depth":
+		return OrderDepth, nil
+	case "radius":
+		return OrderRadius, nil
+	case "none":
+		return OrderNone, nil
+	}
+	return OrderLex, errors.New("unknown sort order \"" + name + "\"; expected lex, depth, radius or none")
+}
+
+// SortBy sorts the seedpack in the given order like SortAligned, applying the
+// same permutation to the aligned depths and smooth depths, either of which
+// may be nil unless the order is by depth.
+func (this seedpack) SortBy(order SeedOrder, depths []int, smooth []float64) (seedpack, []int, []float64, error) {
+	switch order {
+	case OrderNone:
+		return this, depths, smooth, nil
+	case OrderDepth:
+		if len(depths) != len(this) || (smooth != nil && len(smooth) != len(this)) {
+			return this, depths, smooth, errDepthsNotAligned
+		}
+		sort.Stable(seedsByDepth{seedsAndDepths{this, depths, smooth}})
+		return this, depths, smooth, nil
+	case OrderRadius:
+		this, depths, smooth, err := this.SortAligned(depths, smooth)
+		if err != nil {
+			return this, depths, smooth, err
+		}
+		sort.Stable(seedsByRadius{seedsAndDepths{this, depths, smooth}})
+		return this, depths, smooth, nil
 	}
+	return this.SortAligned(depths, smooth)
+}
 
-	outfile = outfile
+// seedsByDepth sorts a seedpack and the aligned slices of a seedsAndDepths by
+// depth, then lexicographically.
+type seedsByDepth struct {
+	seedsAndDepths
+}
 
-	buf.Reset()
-	binary.Write(buf, binary.LittleEndian, []byte("@DM.EMS{codex.apeirography.art} "))
-	for _, c := range seeds {
-		binary.Write(buf, binary.LittleEndian, c)
+func (this seedsByDepth) Less(i, j int) bool {
+	if this.itsDepths[i] != this.itsDepths[j] {
+		return this.itsDepths[i] < this.itsDepths[j]
 	}
-	outfile.Write(buf.Bytes())
+	return this.seedsAndDepths.Less(i, j)
+}
 
-	return
+// seedsByRadius sorts a seedpack and the aligned slices of a seedsAndDepths
+// by distance from the origin. Sorted stably after a lexicographic sort, ties
+// stay in lexicographic order.
+type seedsByRadius struct {
+	seedsAndDepths
 }
 
-// Optimized Mining Function
+func (this seedsByRadius) Less(i, j int) bool {
+	ci, cj := this.itsSeeds[i], this.itsSeeds[j]
+	return real(ci)*real(ci)+imag(ci)*imag(ci) < real(cj)*real(cj)+imag(cj)*imag(cj)
+}
 
-func Mine(howmany, min, max int) (seedpack, int, int) {
+// seedsAndDepths sorts a seedpack together with its aligned depths and
+// smooth depths, if any.
+type seedsAndDepths struct {
+	itsSeeds  seedpack
+	itsDepths []int
+	itsSmooth []float64
+}
 
-	/**** Initialization ****/
+func (this seedsAndDepths) Len() int {
+	return len(this.itsSeeds)
+}
 
-	if howmany < 1 {
-		panic("Number of seeds sought is less than one.")
+func (this seedsAndDepths) Less(i, j int) bool {
+	if real(this.itsSeeds[i]) != real(this.itsSeeds[j]) {
+		return real(this.itsSeeds[i]) < real(this.itsSeeds[j])
+	} else {
+		return imag(this.itsSeeds[i]) < imag(this.itsSeeds[j])
 	}
+}
 
-	if max < min {
-		panic("Maximum seed depth is less than minimum seed depth.")
+func (this seedsAndDepths) Swap(i, j int) {
+	this.itsSeeds[i], this.itsSeeds[j] = this.itsSeeds[j], this.itsSeeds[i]
+	if this.itsDepths != nil {
+		this.itsDepths[i], this.itsDepths[j] = this.itsDepths[j], this.itsDepths[i]
 	}
-
-	if min < 2 {
-		panic("Minimum seed depth is less than 2.")
+	if this.itsSmooth != nil {
+		this.itsSmooth[i], this.itsSmooth[j] = this.itsSmooth[j], this.itsSmooth[i]
 	}
+}
 
-	seeds := NewSeedpack(howmany)
-	sidx := 0
-	guidemap := GenerateGuidemap(51)
-	found := 0
-	relfound := 0
+// Filter recomputes the depth of every seed under formula f for the given
+// bailout radius and returns those with depths in [min, max], along with the
+// shallowest and deepest depth kept.
+func (this seedpack) Filter(min, max int, bailout float64, f Formula) (seedpack, int, int) {
+	filtered, _, realmin, realmax := this.FilterWithDepths(nil, min, max, bailout, f)
+	return filtered, realmin, realmax
+}
 
+// FilterWithDepths is like Filter but takes the depth of every seed from the
+// aligned depths, if not nil, instead of recomputing it, and also returns the
+// depths of the seeds kept.
+func (this seedpack) FilterWithDepths(depths []int, min, max int, bailout float64, f Formula) (seedpack, []int, int, int) {
+	filtered := NewSeedpack(0)
+	kept := []int{}
 	realmin, realmax := max, min
-
-	startTime := time.Now()
-	relstartTime := time.Now()
-	updateInterval := 1
-	fmt.Println("Commencing mining of "+strconv.Itoa(howmany)+" seeds with depths between "+strconv.Itoa(min)+" - "+strconv.Itoa(max)+":")
-
-	b := 2.00 * 2.00
-
-	var z, c, oldz complex128
-	var l, i, j int
-	var repcheck, repcheckstart int
-
-	/**** Outer Loop Begins ****/
-	j = 0
-CheckNewC:
-
-	z = complex(0, 0)
-	c = complex(rand.Float64()*4-2, rand.Float64()*2)
-	l = max + 2
-	i = 0
-	repcheckstart = 2
-	repcheck = repcheckstart
-	oldz = z
-
-	/**** Inner Loop Begins ****/
-	i = 0
-IterateZ:
-	z = z*z + c
-	if repcheck == 0 {
-		if oldz == z {
-			i = -1
-			goto IterateZDone
-		}
-		oldz = z
-		if i%8 == 0 {
-			repcheckstart = repcheckstart + 2
-			if !guidemap.Check(c) && i%64 != 0 {
-				i = -1
-				goto IterateZDone
-			}
+	for idx, c := range this {
+		var depth int
+		if depths != nil {
+			depth = depths[idx]
 		} else {
-			repcheckstart = repcheckstart + 1
+			depth = SeedDepth(c, max, bailout*bailout, f)
 		}
-		repcheck = repcheckstart
-	}
-	repcheck--
-
-	i++
-	if i < l && (real(z)*real(z))+(imag(z)*imag(z)) <= b {
-		goto IterateZ
+		if depth < min || depth > max {
+			continue
+		}
+		if depth < realmin {
+			realmin = depth
+		}
+		if depth > realmax {
+			realmax = depth
+		}
+		filtered = append(filtered, c)
+		kept = append(kept, depth)
 	}
-	/**** Inner Loop Ceases ****/
+	return filtered, kept, realmin, realmax
+}
 
-IterateZDone:
-	if i >= min && i <= max {
-		if i < realmin {
-			realmin = i
+// DepthRange recomputes the depth of every seed under formula f for the
+// squared bailout radius b and returns the shallowest and deepest. Seeds that
+// do not escape within MaxSeedDepth iterations are ignored; if none escape,
+// min exceeds max.
+func (this seedpack) DepthRange(b float64, f Formula) (int, int) {
+	min, max := MaxSeedDepth, 0
+	for _, c := range this {
+		depth := SeedDepth(c, MaxSeedDepth, b, f)
+		if depth < 0 {
+			continue
 		}
-		if i > realmax {
-			realmax = i
+		if depth < min {
+			min = depth
 		}
-		found++
-		relfound++
-		seeds[sidx] = c
-		sidx++
-		guidemap.Mark(c)
-		if relfound % updateInterval == 0 {
-			if time.Since(relstartTime).Seconds() < 45 {
-				if updateInterval > 5 && time.Since(relstartTime).Seconds() > 0 {
-					updateInterval = updateInterval * int(float64(90)/float64(time.Since(relstartTime).Seconds()))
-				}
-				updateInterval++
-				relfound = 0
-				relstartTime = time.Now()
-			} else {
-				totalseconds := int(math.Floor(time.Since(startTime).Seconds()))
-				sps := float64(found)/float64(totalseconds)
-				totalseconds = int((float64(howmany) - float64(found)) / float64(sps))
-				hours := totalseconds / 3600
-				minutes := (totalseconds - (hours * 3600)) / 60
-				seconds := totalseconds - (hours * 3600) - (minutes * 60)
-
-				fmt.Println(strconv.Itoa(found) + " seeds with depths between "+strconv.Itoa(min) + " - " + strconv.Itoa(max)+" found so far. "+ strconv.Itoa(hours) +"h "+strconv.Itoa(minutes)+"m " +strconv.Itoa(seconds) +"s"+" left at current rate of "+strconv.Itoa(int(sps*60*60))+" sph.")
-				relfound = 0
-				relstartTime = time.Now()
-			}
+		if depth > max {
+			max = depth
 		}
 	}
+	return min, max
+}
 
-	j++
-	if found < howmany {
-		goto CheckNewC
+// Equal reports whether the seedpacks hold the same seeds in the same order.
+// Seeds are compared as by SeedsEqual, so unlike with ==, a seed with a NaN
+// component can equal another.
+func (this seedpack) Equal(other seedpack) bool {
+	if len(this) != len(other) {
+		return false
+	}
+	for idx := range this {
+		if !SeedsEqual(this[idx], other[idx]) {
+			return false
+		}
 	}
-	/**** Outer Loop Ceases ****/
+	return true
+}
 
-	totalseconds := int(math.Floor(time.Since(startTime).Seconds()))
-	hours := totalseconds / 3600
-	minutes := (totalseconds - (hours * 3600)) / 60
-	seconds := totalseconds - (hours * 3600) - (minutes * 60)
-	sps := float64(found)/float64(totalseconds)
+// SeedsEqual reports whether a and b are the same seed. Their components are
+// compared with ==, except that any two NaNs compare equal, whatever their
+// sign and payload; == never considers a NaN equal to anything, which would
+// make a seedpack holding one unequal to itself. As with ==, 0 and -0 are
+// equal.
+func SeedsEqual(a, b complex128) bool {
+	return componentsEqual(real(a), real(b)) && componentsEqual(imag(a), imag(b))
+}
 
-	fmt.Println(strconv.Itoa(found) + " seeds with depths between "+strconv.Itoa(min) + " - " + strconv.Itoa(max)+" found after "+ strconv.Itoa(hours) +"h "+strconv.Itoa(minutes)+"m " +strconv.Itoa(seconds) +"s"+" with an overall speed of "+strconv.Itoa(int(sps*60*60))+" sph.")
+func componentsEqual(x, y float64) bool {
+	return x == y || (math.IsNaN(x) && math.IsNaN(y))
+}
 
-	return seeds, realmin, realmax
+// Dedup removes duplicates, as judged by SeedsEqual, from an already sorted
+// seedpack, reusing its backing array.
+func (this seedpack) Dedup() seedpack {
+	if len(this) == 0 {
+		return this
+	}
+	kept := 1
+	for idx := 1; idx < len(this); idx++ {
+		if !SeedsEqual(this[idx], this[kept-1]) {
+			this[kept] = this[idx]
+			kept++
+		}
+	}
+	return this[:kept]
 }
 
-// Seedpack
+// DedupUnsorted removes duplicates, as judged by SeedsEqual, from a seedpack
+// in any order, keeping the first of each where it stands, so that seeds in the
+// order they were found stay in that order. It reuses the backing array.
+func (this seedpack) DedupUnsorted() seedpack {
+	seen := make(map[[2]uint64]bool, len(this))
+	kept := 0
+	for _, c := range this {
+		key := [2]uint64{componentKey(real(c)), componentKey(imag(c))}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		this[kept] = c
+		kept++
+	}
+	return this[:kept]
+}
 
-type seedpack []complex128
+// componentKey returns the bits of x, with the zeros and the NaNs each brought
+// to a single value, so that components equal by componentsEqual share a key.
+func componentKey(x float64) uint64 {
+	switch {
+	case x == 0:
+		return 0
+	case math.IsNaN(x):
+		return math.Float64bits(math.NaN())
+	}
+	return math.Float64bits(x)
+}
 
-func NewSeedpack(howmany int) seedpack {
-	return seedpack(make([]complex128, howmany))
+// Subsample returns n seeds drawn uniformly at random from r without
+// replacement, sorted as by Sort, or all the seeds sorted if there are no more
+// than n. The draw is a single pass of reservoir sampling.
+func (this seedpack) Subsample(n int, r *rand.Rand) seedpack {
+	picked := this.subsampleIndices(n, r)
+	thinned := NewSeedpack(len(picked))
+	for idx, pick := range picked {
+		thinned[idx] = this[pick]
+	}
+	return thinned.Sort()
 }
 
-func (this seedpack) Sort() seedpack {
-	sort.SliceStable(this, func(i, j int) bool {
-		if real(this[i]) != real(this[j]) {
-			return real(this[i]) < real(this[j])
-		} else {
-			return imag(this[i]) < imag(this[j])
+// subsampleIndices returns the indices of the seeds Subsample draws, in no
+// particular order.
+func (this seedpack) subsampleIndices(n int, r *rand.Rand) []int {
+	if n < 0 {
+		n = 0
+	}
+	if n > len(this) {
+		n = len(this)
+	}
+	reservoir := make([]int, n)
+	for idx := range this {
+		if idx < n {
+			reservoir[idx] = idx
+		} else if pick := r.Intn(idx + 1); pick < n {
+			reservoir[pick] = idx
 		}
-	})
-	return this
+	}
+	return reservoir
 }
 
 // Guidemap
 
+// A Guidemap is not safe for concurrent use: a goroutine that marks one must
+// not share it, so that the checks in the mining loop need no lock. Miner
+// gives every worker a Clone of its own and merges them back when it stops.
+// Which cells are marked is kept as a bitset, one bit per cell; only
+// guidemaps that track density also count the hits in every cell.
 type Guidemap struct {
 	itsWidth, itsHeight int
-	itsMinR, itsMaxR float64
-	itsMinI, itsMaxI float64
-	itsDelR, itsDelI float64
-	itsData []bool
+	itsMinR, itsMaxR    float64
+	itsMinI, itsMaxI    float64
+	itsDelR, itsDelI    float64
+	itsBits             []uint64
+	itsCounts           []uint32
+	itsDilate           int
+	itsDisabled         bool
+	itsOrbits           bool
+	itsFormula          Formula
+}
+
+// GenerateGuidemap samples the plane for the given number of seconds and marks
+// every cell in which a moderately deep point was found. With zero seconds
+// every cell is marked, so that Check never rejects a candidate.
+func GenerateGuidemap(size, seconds int) (*Guidemap, error) {
+	return GenerateGuidemapBounds(size, size, FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI, seconds, MandelbrotFormula, false)
 }
 
-func GenerateGuidemap(size int) *Guidemap {
+// GenerateGuidemapBounds is like GenerateGuidemap but covers only the given
+// bounds of the plane with a width×height grid, sampling the set of the given
+// formula. If density is set, the guidemap also counts the hits in every cell,
+// as Weighted mining needs, at the cost of 32 bits per cell rather than one.
+func GenerateGuidemapBounds(width, height int, minR, maxR, minI, maxI float64, seconds int, formula Formula, density bool) (*Guidemap, error) {
+
+	if width < 1 || height < 1 {
+		return nil, errors.New("guidemap size is less than 1")
+	}
+
+	if minR >= maxR || minI >= maxI {
+		return nil, errors.New("guidemap bounds are empty")
+	}
+
+	if seconds < 0 {
+		return nil, errors.New("guidemap generation time is negative")
+	}
+
+	this := NewGuidemap(width, height, minR, maxR, minI, maxI, density)
+
+	if seconds == 0 {
+		logger.Info("guidemap disabled")
+		this.Disable()
+		this.itsFormula = formula
+		return this, nil
+	}
+
+	this.Generate(seconds, formula)
+	return this, nil
+}
 
-	fmt.Print("Generating guidemap... ")
+// Disable marks every cell, with one hit each if the guidemap counts them, so
+// that Check never rejects a candidate.
+func (this *Guidemap) Disable() {
+	for idx := 0; idx < this.itsWidth*this.itsHeight; idx++ {
+		this.setBit(idx)
+		if this.itsCounts != nil {
+			this.itsCounts[idx] = 1
+		}
+	}
+	this.itsDisabled = true
+}
 
+// NewGuidemap returns a guidemap over the given bounds of the plane with a
+// width×height grid and no cell marked, counting hits if density is set.
+func NewGuidemap(width, height int, minR, maxR, minI, maxI float64, density bool) *Guidemap {
 	this := new(Guidemap)
 
-	this.itsWidth = size
-	this.itsHeight = size
+	this.itsWidth = width
+	this.itsHeight = height
 
-	this.itsMinR, this.itsMaxR = -2.00, 2.00
-	this.itsMinI, this.itsMaxI = -2.00, 2.00
+	this.itsMinR, this.itsMaxR = minR, maxR
+	this.itsMinI, this.itsMaxI = minI, maxI
 
 	this.itsDelR = (this.itsMaxR - this.itsMinR) / float64(this.itsWidth)
 	this.itsDelI = (this.itsMaxI - this.itsMinI) / float64(this.itsHeight)
 
-	this.itsData = make([]bool, this.itsWidth * this.itsHeight)
-
-	for idx := 0; idx < len(this.itsData); idx++ {
-		this.itsData[idx] = false
+	this.itsBits = make([]uint64, (this.itsWidth*this.itsHeight+63)/64)
+	if density {
+		this.itsCounts = make([]uint32, this.itsWidth*this.itsHeight)
 	}
+	return this
+}
 
+// Generate marks the cells of points of the guidemap's bounds that escape the
+// set of formula after a number of iterations that rises as more are found,
+// sampling them for the given number of seconds.
+func (this *Guidemap) Generate(seconds int, formula Formula) {
+	logger.Info("generating guidemap", "width", this.itsWidth, "height", this.itsHeight, "seconds", seconds)
 	startTime := time.Now()
+	this.generate(guiderng, formula, func(int) bool {
+		return time.Since(startTime).Seconds() < float64(seconds)
+	})
+}
+
+// GenerateSamples is like Generate but draws the given number of points from
+// r rather than sampling for a while, so that the guidemap depends on nothing
+// but r.
+func (this *Guidemap) GenerateSamples(samples int, r *rand.Rand, formula Formula) {
+	logger.Info("generating guidemap", "width", this.itsWidth, "height", this.itsHeight, "samples", samples)
+	this.generate(r, formula, func(drawn int) bool {
+		return drawn < samples
+	})
+}
+
+// generate draws points from r as long as more, given how many were drawn so
+// far, reports true, and marks them as Generate describes.
+func (this *Guidemap) generate(r *rand.Rand, formula Formula, more func(drawn int) bool) {
+	this.itsFormula = formula
+
+	region := Region{this.itsMinR, this.itsMaxR, this.itsMinI, this.itsMaxI}
 	found := 0
 	limmin := 32
 	limmax := limmin * 2
-	for time.Since(startTime).Seconds() < 60 {
+	for drawn := 0; more(drawn); drawn++ {
 
 		z := complex(0.00, 0.00)
-		c := complex(rand.Float64()*4-2, rand.Float64()*2)
+		c := region.Sample(r)
 
 		for idx := 0; idx < limmax+2; idx++ {
-			z = z*z + c
-			if cmplx.Abs(z) > 2 {
+			z = formula.Step(z, c)
+			if real(z)*real(z)+imag(z)*imag(z) > 4.00 {
 				if idx >= limmin {
 					found++
-					if found % (1000) == 0 {
+					if found%(1000) == 0 {
 						limmax *= 2
 						limmin *= 2
 					}
-					this.Mark(c)
+					if this.itsOrbits {
+						this.markPath(c, idx+1, formula)
+					} else {
+						this.Mark(c)
+					}
 				}
 				break
 			}
 		}
 	}
 
-	fmt.Println("done.")
+	cells := this.itsWidth * this.itsHeight
+	fill := this.FillRatio()
+	logger.Info("generated guidemap", "marked", int(math.Round(fill*float64(cells))), "cells", cells, "fillpercent", math.Round(fill*10000)/100, "hits", found)
+	if fill < MinGuidemapFill {
+		logger.Warn("guidemap is nearly empty; -guidesize may be too fine, or -guidetime too short, to populate it", "fillpercent", math.Round(fill*10000)/100)
+	}
+	logger.Debug("guidemap depth window", "min", limmin, "max", limmax)
 
 	/*
-	for idx := 0; idx < len(this.itsData); idx++ {
-		if idx % this.itsWidth == 0 {
-			fmt.Print("\n")
-		}
-		if this.itsData[idx] {
-			fmt.Print("O")
-		} else {
-			fmt.Print("-")
+		for idx := 0; idx < this.itsWidth*this.itsHeight; idx++ {
+			if idx % this.itsWidth == 0 {
+				fmt.Print("\n")
+			}
+			if this.getBit(idx) {
+				fmt.Print("O")
+			} else {
+				fmt.Print("-")
+			}
 		}
-	}
-	fmt.Print("\n")
+		fmt.Print("\n")
 	*/
-
-	return this
 }
 
 func (this *Guidemap) Print() {
 	fmt.Println("")
-	for idx := 0; idx < len(this.itsData); idx++ {
-		if idx % this.itsWidth == 0 {
+	for idx := 0; idx < this.itsWidth*this.itsHeight; idx++ {
+		if idx%this.itsWidth == 0 {
 			fmt.Print("\n")
 		}
-		if this.itsData[idx] {
+		if this.getBit(idx) {
 			fmt.Print("O")
 		} else {
 			fmt.Print("-")
@@ -326,7 +3018,22 @@ func (this *Guidemap) Print() {
 	fmt.Print("\n")
 }
 
-func (this *Guidemap) Mark(c complex128) {
+// Render draws the guidemap with one pixel per cell, marked cells white and
+// unmarked cells black, with the positive imaginary axis pointing up.
+func (this *Guidemap) Render() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, this.itsWidth, this.itsHeight))
+	for idx := 0; idx < this.itsWidth*this.itsHeight; idx++ {
+		if this.getBit(idx) {
+			x, y := idx%this.itsWidth, idx/this.itsWidth
+			img.SetGray(x, this.itsHeight-1-y, color.Gray{0xff})
+		}
+	}
+	return img
+}
+
+// cell returns the index of the cell containing c. Points
+// outside the guidemap's bounds map to the nearest edge cell.
+func (this *Guidemap) cell(c complex128) int {
 	x := int(math.Round((real(c) - this.itsMinR) / this.itsDelR))
 	y := int(math.Round((imag(c) - this.itsMinI) / this.itsDelI))
 	if x < 0 {
@@ -341,23 +3048,347 @@ func (this *Guidemap) Mark(c complex128) {
 	if y > this.itsHeight-1 {
 		y = this.itsHeight - 1
 	}
-	this.itsData[y*this.itsWidth+x] = true
+	return y*this.itsWidth + x
+}
+
+// getBit reports whether cell idx is marked.
+func (this *Guidemap) getBit(idx int) bool {
+	return this.itsBits[idx/64]&(1<<uint(idx%64)) != 0
+}
+
+// setBit marks cell idx.
+func (this *Guidemap) setBit(idx int) {
+	this.itsBits[idx/64] |= 1 << uint(idx%64)
+}
+
+// Mark marks the cell containing c, counting a hit in it if the guidemap
+// tracks density.
+func (this *Guidemap) Mark(c complex128) {
+	if this.itsDisabled {
+		return
+	}
+	idx := this.cell(c)
+	this.setBit(idx)
+	if this.itsCounts != nil && this.itsCounts[idx] < math.MaxUint32 {
+		this.itsCounts[idx]++
+	}
+}
+
+// MarkPath iterates c under the Mandelbrot formula and, if it escapes within
+// maxIters iterations, marks the cell of every point of its orbit before the
+// escape that lies within the bounds of the guidemap, counting a hit in each
+// if the guidemap tracks density. Orbits that do not escape mark nothing.
+// Rather than where seeds lie, this maps where the orbits of escaping points
+// go, as a Buddhabrot does, at the cost of a mark per iteration.
+func (this *Guidemap) MarkPath(c complex128, maxIters int) {
+	this.markPath(c, maxIters, MandelbrotFormula)
+}
+
+// markPath is MarkPath under the given formula.
+func (this *Guidemap) markPath(c complex128, maxIters int, formula Formula) {
+	if this.itsDisabled {
+		return
+	}
+	orbit := make([]complex128, 0, maxIters)
+	z := complex(0.00, 0.00)
+	for idx := 0; ; idx++ {
+		if idx >= maxIters {
+			return
+		}
+		z = formula.Step(z, c)
+		if real(z)*real(z)+imag(z)*imag(z) > 4.00 {
+			break
+		}
+		orbit = append(orbit, z)
+	}
+
+	for _, z := range orbit {
+		if !this.Contains(z) {
+			continue
+		}
+		idx := this.cell(z)
+		this.setBit(idx)
+		if this.itsCounts != nil && this.itsCounts[idx] < math.MaxUint32 {
+			this.itsCounts[idx]++
+		}
+	}
+}
+
+// TrackOrbits makes Generate mark the cells along the orbit of every point
+// it finds, with MarkPath, rather than the cell of the point alone. This
+// iterates no more points but marks far more cells per point, and so takes
+// correspondingly longer.
+func (this *Guidemap) TrackOrbits() {
+	this.itsOrbits = true
+}
+
+// clearBit unmarks cell idx.
+func (this *Guidemap) clearBit(idx int) {
+	this.itsBits[idx/64] &^= 1 << uint(idx%64)
+}
+
+// Unmark unmarks the cell containing c and forgets its hits, so that Check
+// rejects candidates there from then on. Points outside the bounds of the
+// guidemap are ignored rather than unmarking the edge cell nearest to them.
+func (this *Guidemap) Unmark(c complex128) {
+	if !this.Contains(c) {
+		return
+	}
+	idx := this.cell(c)
+	this.itsDisabled = false
+	this.clearBit(idx)
+	if this.itsCounts != nil {
+		this.itsCounts[idx] = 0
+	}
+}
+
+// Clone returns a deep copy of the guidemap. Marking the copy leaves the
+// original untouched, so copies can be handed to other goroutines or kept as
+// snapshots while mining carries on.
+func (this *Guidemap) Clone() *Guidemap {
+	return &Guidemap{
+		itsWidth:    this.itsWidth,
+		itsHeight:   this.itsHeight,
+		itsMinR:     this.itsMinR,
+		itsMaxR:     this.itsMaxR,
+		itsMinI:     this.itsMinI,
+		itsMaxI:     this.itsMaxI,
+		itsDelR:     this.itsDelR,
+		itsDelI:     this.itsDelI,
+		itsBits:     append([]uint64(nil), this.itsBits...),
+		itsCounts:   append([]uint32(nil), this.itsCounts...),
+		itsDilate:   this.itsDilate,
+		itsDisabled: this.itsDisabled,
+		itsOrbits:   this.itsOrbits,
+		itsFormula:  this.itsFormula,
+	}
 }
 
+// Merge marks every cell marked in other, which must cover the same bounds
+// with the same dimensions. Cells keep the larger of their two hit counts, so
+// merging copies of a common guidemap back into it does not count the hits
+// they share twice.
+func (this *Guidemap) Merge(other *Guidemap) error {
+	if this.itsWidth != other.itsWidth || this.itsHeight != other.itsHeight {
+		return errors.New("cannot merge a " + strconv.Itoa(other.itsWidth) + "x" + strconv.Itoa(other.itsHeight) + " guidemap into a " + strconv.Itoa(this.itsWidth) + "x" + strconv.Itoa(this.itsHeight) + " one")
+	}
+	if this.itsMinR != other.itsMinR || this.itsMaxR != other.itsMaxR || this.itsMinI != other.itsMinI || this.itsMaxI != other.itsMaxI {
+		return errors.New("cannot merge guidemaps covering different bounds")
+	}
+	if this == other {
+		return nil
+	}
+
+	for idx, word := range other.itsBits {
+		this.itsBits[idx] |= word
+	}
+	if this.itsCounts != nil {
+		for idx := range this.itsCounts {
+			hits := uint32(0)
+			if other.itsCounts != nil {
+				hits = other.itsCounts[idx]
+			} else if other.getBit(idx) {
+				hits = 1
+			}
+			if hits > this.itsCounts[idx] {
+				this.itsCounts[idx] = hits
+			}
+		}
+	}
+	return nil
+}
+
+// Check reports whether the cell containing c has been marked at all, or,
+// if the guidemap is dilated, any cell within the dilation radius of it.
 func (this *Guidemap) Check(c complex128) bool {
-	x := int(math.Round((real(c) - this.itsMinR) / this.itsDelR))
-	y := int(math.Round((imag(c) - this.itsMinI) / this.itsDelI))
-	if x < 0 {
-		x = 0
+	if this.itsDisabled {
+		return true
 	}
-	if x > this.itsWidth-1 {
-		x = this.itsWidth - 1
+	idx := this.cell(c)
+	if this.getBit(idx) || this.itsDilate == 0 {
+		return this.getBit(idx)
 	}
-	if y < 0 {
-		y = 0
+
+	x, y := idx%this.itsWidth, idx/this.itsWidth
+	for ny := y - this.itsDilate; ny <= y+this.itsDilate; ny++ {
+		if ny < 0 || ny >= this.itsHeight {
+			continue
+		}
+		for nx := x - this.itsDilate; nx <= x+this.itsDilate; nx++ {
+			if nx >= 0 && nx < this.itsWidth && this.getBit(ny*this.itsWidth+nx) {
+				return true
+			}
+		}
 	}
-	if y > this.itsHeight-1 {
-		y = this.itsHeight - 1
+	return false
+}
+
+// Disabled reports whether the guidemap was disabled, by Disable or by
+// generating it with no time to do so. Every cell of a disabled guidemap is
+// marked, so it rejects nothing, and Check and Mark return at once. It stays
+// disabled until a cell is unmarked.
+func (this *Guidemap) Disabled() bool {
+	return this.itsDisabled
+}
+
+// Contains reports whether c lies within the bounds of the guidemap.
+func (this *Guidemap) Contains(c complex128) bool {
+	return real(c) >= this.itsMinR && real(c) <= this.itsMaxR && imag(c) >= this.itsMinI && imag(c) <= this.itsMaxI
+}
+
+// Dilate makes Check also accept points whose cell lies within radius cells,
+// in both directions, of a marked cell, so that a productive point just
+// across the edge of a marked cell is not rejected. Only the cells themselves
+// are checked more widely; nothing is marked. A radius of 0 checks the cell
+// alone.
+func (this *Guidemap) Dilate(radius int) {
+	this.itsDilate = radius
+}
+
+// Density returns the number of hits marked in the cell containing c. A
+// guidemap that does not track density reports one hit for a marked cell.
+func (this *Guidemap) Density(c complex128) uint32 {
+	idx := this.cell(c)
+	if this.itsCounts != nil {
+		return this.itsCounts[idx]
+	}
+	if this.getBit(idx) {
+		return 1
+	}
+	return 0
+}
+
+// MeanDensity returns the average number of hits over the marked cells.
+func (this *Guidemap) MeanDensity() float64 {
+	hits, marked := 0.0, 0
+	for idx := 0; idx < this.itsWidth*this.itsHeight; idx++ {
+		if !this.getBit(idx) {
+			continue
+		}
+		if this.itsCounts != nil {
+			hits += float64(this.itsCounts[idx])
+		} else {
+			hits++
+		}
+		marked++
+	}
+	if marked == 0 {
+		return 0
+	}
+	return hits / float64(marked)
+}
+
+// FillRatio returns the fraction of cells that are marked.
+func (this *Guidemap) FillRatio() float64 {
+	marked := 0
+	for idx := 0; idx < this.itsWidth*this.itsHeight; idx++ {
+		if this.getBit(idx) {
+			marked++
+		}
+	}
+	return float64(marked) / float64(this.itsWidth*this.itsHeight)
+}
+
+// MinGuidemapFill is the FillRatio below which a freshly generated guidemap is
+// reported as suspiciously empty. The boundary of the set crosses far more
+// than this share of the cells of any reasonable grid over it.
+const MinGuidemapFill = 0.01
+
+// Guidemap files
+
+// GuidemapHeader is the magic string every guidemap file starts with.
+const GuidemapHeader = "@DM.EMG{codex.apeirography.art} "
+
+type guidemapFileHeader struct {
+	Width, Height int32
+	MinR, MaxR    float64
+	MinI, MaxI    float64
+	DelR, DelI    float64
+}
+
+// guidemapFileFormula follows the cells of guidemap files that record the
+// formula they were generated for. Older files end with the cells.
+type guidemapFileFormula struct {
+	Variant, Power int32
+}
+
+// SaveGuidemap writes the guidemap to path, packing its cells eight to a byte.
+// Only whether a cell is marked is kept; hit counts are not.
+func (this *Guidemap) SaveGuidemap(path string) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(GuidemapHeader)
+	binary.Write(buf, binary.LittleEndian, guidemapFileHeader{
+		int32(this.itsWidth), int32(this.itsHeight),
+		this.itsMinR, this.itsMaxR,
+		this.itsMinI, this.itsMaxI,
+		this.itsDelR, this.itsDelI,
+	})
+
+	// Stored little-endian, the words of the bitset put cell idx at bit idx%8
+	// of byte idx/8, as the file format wants.
+	bits := new(bytes.Buffer)
+	binary.Write(bits, binary.LittleEndian, this.itsBits)
+	buf.Write(bits.Bytes()[:(this.itsWidth*this.itsHeight+7)/8])
+	if this.itsFormula.Power != 0 {
+		binary.Write(buf, binary.LittleEndian, guidemapFileFormula{int32(this.itsFormula.Variant), int32(this.itsFormula.Power)})
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// LoadGuidemap reads a guidemap written by SaveGuidemap.
+func LoadGuidemap(path string) (*Guidemap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(GuidemapHeader) || string(data[:len(GuidemapHeader)]) != GuidemapHeader {
+		return nil, errors.New(path + ": not a guidemap file (bad header)")
+	}
+	r := bytes.NewReader(data[len(GuidemapHeader):])
+
+	var header guidemapFileHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, errors.New(path + ": truncated guidemap header")
+	}
+	if header.Width < 1 || header.Height < 1 {
+		return nil, errors.New(path + ": invalid guidemap dimensions")
+	}
+
+	this := new(Guidemap)
+	this.itsWidth, this.itsHeight = int(header.Width), int(header.Height)
+	this.itsMinR, this.itsMaxR = header.MinR, header.MaxR
+	this.itsMinI, this.itsMaxI = header.MinI, header.MaxI
+	this.itsDelR, this.itsDelI = header.DelR, header.DelI
+	this.itsBits = make([]uint64, (this.itsWidth*this.itsHeight+63)/64)
+
+	bits := make([]byte, len(this.itsBits)*8)
+	if _, err := io.ReadFull(r, bits[:(this.itsWidth*this.itsHeight+7)/8]); err != nil {
+		return nil, errors.New(path + ": truncated guidemap data")
+	}
+	binary.Read(bytes.NewReader(bits), binary.LittleEndian, this.itsBits)
+
+	var formula guidemapFileFormula
+	if binary.Read(r, binary.LittleEndian, &formula) == nil {
+		this.itsFormula = Formula{Variant(formula.Variant), int(formula.Power)}
+	}
+
+	return this, nil
+}
+
+// Matches returns an error describing how the guidemap differs from one
+// covering region with a width×height grid for formula, or nil if it does
+// not. The formula of a guidemap that does not record one is not compared.
+func (this *Guidemap) Matches(width, height int, region Region, formula Formula) error {
+	if this.itsWidth != width || this.itsHeight != height {
+		return errors.New("guidemap is " + strconv.Itoa(this.itsWidth) + "x" + strconv.Itoa(this.itsHeight) + ", not " + strconv.Itoa(width) + "x" + strconv.Itoa(height))
+	}
+	if this.itsMinR != region.MinR || this.itsMaxR != region.MaxR || this.itsMinI != region.MinI || this.itsMaxI != region.MaxI {
+		return errors.New("guidemap covers " + Region{this.itsMinR, this.itsMaxR, this.itsMinI, this.itsMaxI}.String() + ", not " + region.String())
+	}
+	if this.itsFormula.Power != 0 && this.itsFormula != formula {
+		return errors.New("guidemap was generated for the " + this.itsFormula.String() + ", not the " + formula.String())
 	}
-	return this.itsData[y*this.itsWidth+x]
+	return nil
 }
@@ -0,0 +1,474 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// errDiskFull is the error a failingWriter fails with.
+var errDiskFull = errors.New("disk full")
+
+// failingWriter takes the first limit bytes written to it and then fails, as a
+// file on a disk that fills up does.
+type failingWriter struct {
+	limit int
+}
+
+func (this *failingWriter) Write(p []byte) (int, error) {
+	if len(p) <= this.limit {
+		this.limit -= len(p)
+		return len(p), nil
+	}
+	n := this.limit
+	this.limit = 0
+	return n, errDiskFull
+}
+
+func TestWriteEMSReportsShortWrite(t *testing.T) {
+	seeds := seedpack{complex(-1.5, 0.25), complex(0.25, -0.5), complex(-0.75, 0.125)}
+	for _, limit := range []int{0, 8, 40} {
+		if _, err := WriteEMS(&failingWriter{limit}, EMSContents{Seeds: seeds}); !errors.Is(err, errDiskFull) {
+			t.Errorf("WriteEMS after %d bytes: got error %v, want %v", limit, err, errDiskFull)
+		}
+		if _, err := SaveEMS(&failingWriter{limit}, seeds, nil, nil, 2, 10, 3, 7, 64, OrderLex); !errors.Is(err, errDiskFull) {
+			t.Errorf("SaveEMS after %d bytes: got error %v, want %v", limit, err, errDiskFull)
+		}
+	}
+}
+
+// cellPoint returns a point in cell idx of a guidemap made by
+// NewGuidemap(width, height, 0, width, 0, height, ...), whose cells are a unit
+// wide and centred on whole coordinates.
+func cellPoint(idx, width int) complex128 {
+	return complex(float64(idx%width), float64(idx/width))
+}
+
+func TestGuidemapMarkCheckWordBoundaries(t *testing.T) {
+	for _, size := range [][2]int{{8, 8}, {9, 9}, {13, 5}, {65, 1}, {1, 130}} {
+		width, height := size[0], size[1]
+		cells := width * height
+		for _, marked := range []int{0, 62, 63, 64, 65, 127, 128, cells - 1} {
+			if marked >= cells {
+				continue
+			}
+			guidemap := NewGuidemap(width, height, 0, float64(width), 0, float64(height), false)
+			guidemap.Mark(cellPoint(marked, width))
+			for idx := 0; idx < cells; idx++ {
+				if got := guidemap.Check(cellPoint(idx, width)); got != (idx == marked) {
+					t.Errorf("%dx%d guidemap with cell %d marked: Check of cell %d = %v", width, height, marked, idx, got)
+				}
+			}
+		}
+	}
+}
+
+// emsSize is the size of a version 2 .ems file of n seeds: the magic string,
+// the version field, the metadata block, the seeds as float64 pairs and the
+// CRC footer.
+func emsSize(n int) int64 {
+	return int64(len(EMSHeader) + 2 + binary.Size(emsMetadataFields{}) + 16*n + 4)
+}
+
+// testSeeds returns n distinct seeds.
+func testSeeds(n int) seedpack {
+	seeds := NewSeedpack(n)
+	for idx := range seeds {
+		seeds[idx] = complex(-2+float64(idx)/float64(n), 0.5-float64(idx)/float64(2*n))
+	}
+	return seeds
+}
+
+func TestSaveEMSFileLength(t *testing.T) {
+	for _, n := range []int{0, 1, 3, 1000} {
+		path, err := SaveEMSFile(testSeeds(n), nil, nil, 20, 40, 22, 38, filepath.Join(t.TempDir(), "seeds.ems"), false, 64, OrderLex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != emsSize(n) {
+			t.Errorf("%d seeds: file is %d bytes, want %d", n, info.Size(), emsSize(n))
+		}
+	}
+}
+
+func TestSaveEMSFileTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds.ems")
+	if _, err := SaveEMSFile(testSeeds(1000), nil, nil, 20, 40, 22, 38, path, false, 64, OrderLex); err != nil {
+		t.Fatal(err)
+	}
+	small := testSeeds(3)
+	if _, err := SaveEMSFile(small, nil, nil, 20, 40, 22, 38, path, false, 64, OrderLex); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Size() != emsSize(len(small)) {
+		t.Errorf("file is %d bytes after overwriting, want %d", info.Size(), emsSize(len(small)))
+	}
+	seeds, _, err := LoadEMSFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seeds) != len(small) {
+		t.Fatalf("read back %d seeds, want %d", len(seeds), len(small))
+	}
+	for idx, c := range small.Sort() {
+		if seeds[idx] != c {
+			t.Errorf("seed %d is %v, want %v", idx, seeds[idx], c)
+		}
+	}
+}
+
+func TestMineSummaryIsFinite(t *testing.T) {
+	var out bytes.Buffer
+	defer func(saved *slog.Logger) { logger = saved }(logger)
+	logger = slog.New(slog.NewTextHandler(&out, nil))
+
+	miner := NewMiner(1, 2, 10)
+	miner.Guidemap = NewGuidemap(51, 51, FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI, false)
+	miner.Guidemap.Disable()
+	miner.Rand = rand.New(rand.NewSource(1))
+	if _, _, err := miner.MineSeeds(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var summary string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "msg=\"mining finished\"") {
+			summary = line
+		}
+	}
+	if summary == "" {
+		t.Fatalf("no mining summary logged in:\n%s", out.String())
+	}
+	if strings.Contains(summary, "Inf") || strings.Contains(summary, "NaN") {
+		t.Errorf("mining summary is not finite: %s", summary)
+	}
+}
+
+// TestEscapeDepthDetectsPeriodicity iterates interior points under formulas
+// that do not skip the cardioid and bulb. An orbit that merely runs out of
+// iterations returns max+2, so only the periodicity check returns -1.
+func TestEscapeDepthDetectsPeriodicity(t *testing.T) {
+	const max = 10000
+	for _, tc := range []struct {
+		c complex128
+		f Formula
+	}{
+		// On the real axis the Tricorn iterates exactly as the Mandelbrot set.
+		{complex(-0.5, 0), Formula{Tricorn, 2}},
+		{complex(-1, 0), Formula{Tricorn, 2}},
+		{complex(0.1, 0.1), Formula{Mandelbrot, 3}},
+	} {
+		if tc.f.SkipsInterior(tc.c) {
+			t.Fatalf("%v skips the interior point %v", tc.f, tc.c)
+		}
+		if depth, _ := escapeDepth(tc.c, max, 4, DefaultPeriodTolerance*DefaultPeriodTolerance, tc.f, nil); depth != -1 {
+			t.Errorf("escapeDepth(%v) under %v = %d, want -1", tc.c, tc.f, depth)
+		}
+	}
+}
+
+// BenchmarkEscapeDepth iterates a fixed uniform sample of the plane, drawn as
+// mining draws candidates without a guidemap: most escape within a few
+// iterations or are skipped as interior, and a few run deep.
+func BenchmarkEscapeDepth(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	points := make([]complex128, 1024)
+	for idx := range points {
+		points[idx] = FullRegion.Sample(r)
+	}
+	tolerance := DefaultPeriodTolerance * DefaultPeriodTolerance
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		escapeDepth(points[n%len(points)], 1000, 4, tolerance, MandelbrotFormula, nil)
+	}
+}
+
+func TestMineRandDeterministic(t *testing.T) {
+	want := seedpack{
+		complex(-0.24937437363104253, -0.7281189198492306),
+		complex(-1.2458339480251053, 0.08133374318696163),
+		complex(-0.23435179166690112, 0.8099423465213476),
+		complex(-1.1948943348198138, 0.29725158657987416),
+		complex(0.13082420502755365, -0.6495150545638217),
+	}
+	seeds, realmin, realmax := MineRand(rand.New(rand.NewSource(42)), len(want), 20, 40)
+	if len(seeds) != len(want) {
+		t.Fatalf("mined %d seeds, want %d", len(seeds), len(want))
+	}
+	for idx, c := range want {
+		if seeds[idx] != c {
+			t.Errorf("seed %d is %v, want %v", idx, seeds[idx], c)
+		}
+	}
+	if realmin != 20 || realmax != 33 {
+		t.Errorf("depth range is %d - %d, want 20 - 33", realmin, realmax)
+	}
+}
+
+func TestGuidemapCloneIsolated(t *testing.T) {
+	original := NewGuidemap(9, 9, 0, 9, 0, 9, true)
+	original.Mark(cellPoint(10, 9))
+	fill, density := original.FillRatio(), original.Density(cellPoint(10, 9))
+
+	clone := original.Clone()
+	for _, idx := range []int{10, 11, 40, 80} {
+		clone.Mark(cellPoint(idx, 9))
+	}
+	clone.Unmark(cellPoint(10, 9))
+
+	if !original.Check(cellPoint(10, 9)) {
+		t.Error("unmarking the clone unmarked the original")
+	}
+	for _, idx := range []int{11, 40, 80} {
+		if original.Check(cellPoint(idx, 9)) {
+			t.Errorf("marking cell %d of the clone marked the original", idx)
+		}
+	}
+	if got := original.FillRatio(); got != fill {
+		t.Errorf("original fill ratio is %v after marking the clone, want %v", got, fill)
+	}
+	if got := original.Density(cellPoint(10, 9)); got != density {
+		t.Errorf("original density is %d after marking the clone, want %d", got, density)
+	}
+}
+
+func TestLoadGuidemapMatches(t *testing.T) {
+	region := Region{0, 9, 0, 9}
+	tricorn := Formula{Tricorn, 2}
+	guidemap := NewGuidemap(9, 9, region.MinR, region.MaxR, region.MinI, region.MaxI, false)
+	guidemap.itsFormula = tricorn
+	path := filepath.Join(t.TempDir(), "guide.emg")
+	if err := guidemap.SaveGuidemap(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGuidemap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loaded.Matches(9, 9, region, tricorn); err != nil {
+		t.Errorf("guidemap does not match its own settings: %v", err)
+	}
+	for name, err := range map[string]error{
+		"size":    loaded.Matches(8, 9, region, tricorn),
+		"region":  loaded.Matches(9, 9, Region{0, 9, -9, 9}, tricorn),
+		"formula": loaded.Matches(9, 9, region, MandelbrotFormula),
+	} {
+		if err == nil {
+			t.Errorf("guidemap of another %s matches", name)
+		}
+	}
+
+	// Guidemaps that do not record their formula match any.
+	loaded.itsFormula = Formula{}
+	if err := loaded.Matches(9, 9, region, MandelbrotFormula); err != nil {
+		t.Errorf("guidemap without a formula does not match: %v", err)
+	}
+}
+
+func TestMineProgressCadence(t *testing.T) {
+	const interval, timeout = 20 * time.Millisecond, 210 * time.Millisecond
+
+	// Every candidate of a region inside the main cardioid is skipped, so no
+	// seed is ever found and mining runs until the timeout.
+	miner := NewMiner(1, 20, 40)
+	miner.Guidemap = NewGuidemap(51, 51, FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI, false)
+	miner.Guidemap.Disable()
+	miner.Rand = rand.New(rand.NewSource(1))
+	miner.Region = Region{-0.1, 0.1, -0.1, 0.1}
+	miner.ProgressInterval = interval
+	calls := 0
+	miner.ProgressFunc = func(stats Stats) {
+		calls++
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, _, err := miner.MineSeeds(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("mining stopped with %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// A loaded machine may drop ticks, but never adds any beyond the one that
+	// may race the timeout.
+	if most := int(timeout/interval) + 1; calls < most/2 || calls > most {
+		t.Errorf("ProgressFunc called %d times in %v at intervals of %v, want %d at most and %d at least", calls, timeout, interval, most, most/2)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata from the current output")
+
+// TestEMSGolden locks down the .ems encoding, from the header and metadata
+// block through the little-endian seeds in lexicographic order to the CRC
+// footer, by comparing the encoding of a reproducible mine against a checked-in
+// file. Regenerate the file with -update only when the format is meant to
+// change.
+func TestEMSGolden(t *testing.T) {
+	const golden = "testdata/minerand42.ems"
+
+	seeds, realmin, realmax := MineRand(rand.New(rand.NewSource(42)), 16, 20, 40)
+	var buf bytes.Buffer
+	if _, err := SaveEMS(&buf, seeds, nil, nil, 20, 40, realmin, realmax, 64, OrderLex); err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("encoding differs from %s:\ngot  %x\nwant %x", golden, buf.Bytes(), want)
+	}
+
+	// A saved file must hold exactly what SaveEMS encodes.
+	path, err := SaveEMSFile(seeds, nil, nil, 20, 40, realmin, realmax, filepath.Join(t.TempDir(), "seeds.ems"), false, 64, OrderLex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, buf.Bytes()) {
+		t.Errorf("SaveEMSFile wrote %x, unlike SaveEMS's %x", saved, buf.Bytes())
+	}
+}
+
+func TestSeedpackEqual(t *testing.T) {
+	nan := math.NaN()
+	seeds := seedpack{complex(-1.5, 0.25), complex(0, math.Copysign(0, -1)), complex(nan, 1)}
+	for _, tc := range []struct {
+		name  string
+		other seedpack
+		want  bool
+	}{
+		{"same seeds", seedpack{complex(-1.5, 0.25), 0, complex(nan, 1)}, true},
+		{"different order", seedpack{0, complex(-1.5, 0.25), complex(nan, 1)}, false},
+		{"fewer seeds", seeds[:2], false},
+		{"more seeds", append(append(seedpack(nil), seeds...), 1), false},
+		{"different seed", seedpack{complex(-1.5, 0.5), 0, complex(nan, 1)}, false},
+	} {
+		if got := seeds.Equal(tc.other); got != tc.want {
+			t.Errorf("%s: Equal = %v, want %v", tc.name, got, tc.want)
+		}
+		if got := tc.other.Equal(seeds); got != tc.want {
+			t.Errorf("%s: reversed Equal = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if !seeds.Equal(seeds) {
+		t.Error("a seedpack holding a NaN does not equal itself")
+	}
+}
+
+func TestDedupUnsortedKeepsOrder(t *testing.T) {
+	seeds := seedpack{complex(0.5, 0.25), complex(-1, 0), complex(0.5, 0.25), complex(-1, math.Copysign(0, -1)), complex(0.25, 0)}
+	want := seedpack{complex(0.5, 0.25), complex(-1, 0), complex(0.25, 0)}
+	if got := seeds.DedupUnsorted(); !got.Equal(want) {
+		t.Errorf("DedupUnsorted = %v, want %v", got, want)
+	}
+}
+
+func TestEvaluateCandidates(t *testing.T) {
+	in := strings.NewReader("2,0\n\n0,0\n0.26,0\nnot a point\n-1.75, 0.0625\n")
+	var out bytes.Buffer
+	accepted, depths, invalid, err := EvaluateCandidates(in, &out, 1, 10, 4, DefaultPeriodTolerance*DefaultPeriodTolerance, MandelbrotFormula)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.26 escapes, but only after far more than max iterations.
+	want := "2,0,2,true\n0,0,-1,false\n0.26,0,-1,false\n-1.75,0.0625,7,true\n"
+	if out.String() != want {
+		t.Errorf("report is\n%s\nwant\n%s", out.String(), want)
+	}
+	if !accepted.Equal(seedpack{complex(2, 0), complex(-1.75, 0.0625)}) || len(depths) != 2 || depths[0] != 2 || depths[1] != 7 {
+		t.Errorf("accepted %v with depths %v", accepted, depths)
+	}
+	if invalid != 1 {
+		t.Errorf("%d malformed lines, want 1", invalid)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"min": 5, "howmany": 7, "maxtime": "2h"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	min := flags.Int("min", 100, "")
+	howmany := flags.Int("howmany", 1000, "")
+	flags.IntVar(howmany, "count", *howmany, "")
+	maxtime := flags.Duration("maxtime", 0, "")
+	if err := flags.Parse([]string{"-count", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Apply(flags); err != nil {
+		t.Fatal(err)
+	}
+	if *min != 5 || *howmany != 3 || *maxtime != 2*time.Hour {
+		t.Errorf("got min %d, howmany %d, maxtime %v; want 5, 3 (from -count) and 2h", *min, *howmany, *maxtime)
+	}
+
+	for _, contents := range []string{`{"mni": 5}`, `{"min": "5"}`, `{"count": 3}`} {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("config %s was accepted", contents)
+		}
+	}
+}
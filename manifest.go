@@ -0,0 +1,116 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Manifests
+
+// ManifestName is the name of the manifest -jobs keeps next to its outputs.
+const ManifestName = "manifest.json"
+
+// ManifestEntry describes one file listed in a manifest. Path is relative to
+// the directory of the manifest, and MD5 is taken over the whole file as
+// saved.
+type ManifestEntry struct {
+	Path    string `json:"path"`
+	Min     int    `json:"min"`
+	Max     int    `json:"max"`
+	RealMin int    `json:"realmin"`
+	RealMax int    `json:"realmax"`
+	Count   int    `json:"count"`
+	MD5     string `json:"md5"`
+	SavedAt string `json:"savedAt"`
+}
+
+// LoadManifest reads the entries of the manifest at path, which is a JSON
+// array of ManifestEntry. A manifest that does not exist yet has no entries.
+func LoadManifest(path string) ([]ManifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return entries, nil
+}
+
+// AppendManifest adds the file saved at saved, holding count seeds mined for
+// depths [min, max] and found in [realmin, realmax], to the manifest at path,
+// creating the manifest if need be. An entry already listing the same file is
+// replaced, so that rewriting a file does not list it twice. The manifest is
+// rewritten through a temporary file, so that it is never left half-written
+// and entries from earlier sessions accumulate.
+func AppendManifest(path, saved string, min, max, realmin, realmax, count int) error {
+	entries, err := LoadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(saved)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(filepath.Dir(path), saved)
+	if err != nil {
+		rel = saved
+	}
+	entry := ManifestEntry{
+		Path:    filepath.ToSlash(rel),
+		Min:     min,
+		Max:     max,
+		RealMin: realmin,
+		RealMax: realmax,
+		Count:   count,
+		MD5:     fmt.Sprintf("%x", md5.Sum(data)),
+		SavedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	replaced := false
+	for idx := range entries {
+		if entries[idx].Path == entry.Path {
+			entries[idx], replaced = entry, true
+		}
+	}
+	if !replaced {
+		entries = append(entries, entry)
+	}
+
+	doc, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(doc, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
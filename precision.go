@@ -0,0 +1,185 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"math/big"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Arbitrary-Precision Mining
+
+// mineWorkerBig is mineWorker with the orbit iterated in big.Float arithmetic
+// of prec mantissa bits. Candidates are still drawn and stored as complex128;
+// only the iteration, where rounding errors compound, runs at high precision.
+func mineWorkerBig(r *rand.Rand, sample func() complex128, min, max int, b, t, mean float64, prec uint, v Variant, smooth bool, neighbors int, radius float64, guidemap *Guidemap, candidates *atomic.Int64, results chan<- Seed, done <-chan struct{}) {
+
+	iterator := newBigIterator(prec, b, t, v)
+	formula := Formula{v, 2}
+
+	send := func(c complex128, i int) bool {
+		s := Seed{C: c, Depth: i}
+		if smooth {
+			s.Smooth = SmoothDepth(i, iterator.Z(), 2)
+		}
+		guidemap.Mark(c)
+		select {
+		case results <- s:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for j := 1; ; j++ {
+		c := sample()
+
+		i := -1
+		if !formula.SkipsInterior(c) {
+			if mean <= 0 || keepWeighted(r, c, mean, guidemap) {
+				i = iterator.Depth(c, max+2, guidemap.Check(c))
+			}
+		}
+
+		if i >= min && i <= max {
+			if !send(c, i) {
+				candidates.Add(int64(j % 64))
+				return
+			}
+			probes, kept := 0, 0
+			for ; probes < neighbors*NeighborProbes && kept < neighbors; probes++ {
+				n := c + Perturbation(r, radius)
+				if formula.SkipsInterior(n) {
+					continue
+				}
+				if i := iterator.Depth(n, max+2, guidemap.Check(n)); i >= min && i <= max {
+					kept++
+					if !send(n, i) {
+						candidates.Add(int64(j%64 + probes + 1))
+						return
+					}
+				}
+			}
+			candidates.Add(int64(probes))
+		}
+
+		if j%64 == 0 {
+			candidates.Add(64)
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// bigIterator holds the big.Float registers for iterating one orbit at a time,
+// so that they are allocated once per worker rather than once per candidate.
+type bigIterator struct {
+	itsZR, itsZI     *big.Float
+	itsCR, itsCI     *big.Float
+	itsOldR, itsOldI *big.Float
+	itsT1, itsT2     *big.Float
+	itsT3            *big.Float
+	itsB, itsT       *big.Float
+	itsVariant       Variant
+}
+
+func newBigIterator(prec uint, b, t float64, v Variant) *bigIterator {
+	this := &bigIterator{itsVariant: v}
+	for _, f := range []**big.Float{
+		&this.itsZR, &this.itsZI, &this.itsCR, &this.itsCI,
+		&this.itsOldR, &this.itsOldI, &this.itsT1, &this.itsT2, &this.itsT3,
+	} {
+		*f = new(big.Float).SetPrec(prec)
+	}
+	this.itsB = new(big.Float).SetPrec(prec).SetFloat64(b)
+	this.itsT = new(big.Float).SetPrec(prec).SetFloat64(t)
+	return this
+}
+
+// Depth iterates c for at most limit iterations and returns its depth, or -1
+// if the orbit returns to within the periodicity tolerance of an earlier point
+// or, unless marked, lies in a guidemap cell known to be unproductive. It
+// follows the same periodicity-check schedule as mineWorker.
+func (this *bigIterator) Depth(c complex128, limit int, marked bool) int {
+	this.itsZR.SetFloat64(0)
+	this.itsZI.SetFloat64(0)
+	this.itsCR.SetFloat64(real(c))
+	this.itsCI.SetFloat64(imag(c))
+	this.itsOldR.SetFloat64(0)
+	this.itsOldI.SetFloat64(0)
+
+	repcheckstart := 2
+	repcheck := repcheckstart
+
+	for i := 0; ; {
+		// z = z*z + c, conjugating z first for the Tricorn
+		this.itsT1.Mul(this.itsZR, this.itsZR)
+		this.itsT2.Mul(this.itsZI, this.itsZI)
+		this.itsT3.Mul(this.itsZR, this.itsZI)
+		this.itsZR.Sub(this.itsT1, this.itsT2)
+		this.itsZR.Add(this.itsZR, this.itsCR)
+		this.itsZI.Add(this.itsT3, this.itsT3)
+		if this.itsVariant == Tricorn {
+			this.itsZI.Neg(this.itsZI)
+		}
+		this.itsZI.Add(this.itsZI, this.itsCI)
+
+		if repcheck == 0 {
+			this.itsT1.Sub(this.itsZR, this.itsOldR)
+			this.itsT2.Sub(this.itsZI, this.itsOldI)
+			this.itsT1.Mul(this.itsT1, this.itsT1)
+			this.itsT2.Mul(this.itsT2, this.itsT2)
+			if this.itsT1.Add(this.itsT1, this.itsT2).Cmp(this.itsT) <= 0 {
+				return -1
+			}
+			this.itsOldR.Set(this.itsZR)
+			this.itsOldI.Set(this.itsZI)
+			if i%8 == 0 {
+				repcheckstart = repcheckstart + 2
+				if !marked && i%64 != 0 {
+					return -1
+				}
+			} else {
+				repcheckstart = repcheckstart + 1
+			}
+			repcheck = repcheckstart
+		}
+		repcheck--
+
+		i++
+		this.itsT1.Mul(this.itsZR, this.itsZR)
+		this.itsT2.Mul(this.itsZI, this.itsZI)
+		this.itsT1.Add(this.itsT1, this.itsT2)
+		if i >= limit || this.itsT1.Cmp(this.itsB) > 0 {
+			return i
+		}
+	}
+}
+
+// Z returns the last point of the orbit iterated by Depth, rounded to
+// complex128.
+func (this *bigIterator) Z() complex128 {
+	zr, _ := this.itsZR.Float64()
+	zi, _ := this.itsZI.Float64()
+	return complex(zr, zi)
+}
@@ -0,0 +1,82 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// Profiling
+
+// StartProfiling starts writing a CPU profile to cpuprofile, unless it is
+// empty, and returns a function that stops it and writes a heap profile to
+// memprofile, unless that is empty. The function may be called more than
+// once; only the first call does anything. Mining stops cleanly on an
+// interrupt, so calling it once mining returns flushes the profiles of
+// interrupted runs too.
+func StartProfiling(cpuprofile, memprofile string) (func(), error) {
+	var cpufile *os.File
+	if cpuprofile != "" {
+		var err error
+		if cpufile, err = os.Create(cpuprofile); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpufile); err != nil {
+			cpufile.Close()
+			return nil, err
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if cpufile != nil {
+				pprof.StopCPUProfile()
+				if err := cpufile.Close(); err != nil {
+					logger.Warn("cannot write CPU profile", "path", cpuprofile, "err", err)
+				} else {
+					logger.Info("wrote CPU profile", "path", cpuprofile)
+				}
+			}
+			if memprofile != "" {
+				runtime.GC()
+				if err := writeHeapProfile(memprofile); err != nil {
+					logger.Warn("cannot write memory profile", "path", memprofile, "err", err)
+				} else {
+					logger.Info("wrote memory profile", "path", memprofile)
+				}
+			}
+		})
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
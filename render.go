@@ -0,0 +1,241 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sync"
+)
+
+// Seed rendering
+
+// RenderSeeds plots every seed as a white pixel on a black width×height image
+// of the [-2,2]×[-2,2] plane, with the positive imaginary axis pointing up.
+func RenderSeeds(seeds seedpack, width, height int) *image.RGBA {
+	return RenderSeedsBounds(seeds, width, height, FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI)
+}
+
+// RenderSeedsBounds is like RenderSeeds but plots the given bounds of the
+// plane, which must not be empty. Seeds outside them are drawn on the edge.
+func RenderSeedsBounds(seeds seedpack, width, height int, minR, maxR, minI, maxI float64) *image.RGBA {
+	return seeds.ToImageBounds(width, height, nil, func(int) color.RGBA {
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}
+	}, minR, maxR, minI, maxI)
+}
+
+// ToImage plots every seed as a pixel on a black width×height image of the
+// [-2,2]×[-2,2] plane, colored by palette according to its escape depth,
+// taken from depths, which must be aligned with the seedpack. If palette is
+// nil, DepthPalette is used.
+func (this seedpack) ToImage(width, height int, depths []int, palette func(depth int) color.RGBA) *image.RGBA {
+	return this.ToImageBounds(width, height, depths, palette, FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI)
+}
+
+// ToImageBounds is like ToImage but plots the given bounds of the plane, which
+// must not be empty, as RenderSeedsBounds does. If depths is nil, every seed
+// is colored as palette colors depth 0.
+func (this seedpack) ToImageBounds(width, height int, depths []int, palette func(depth int) color.RGBA, minR, maxR, minI, maxI float64) *image.RGBA {
+	if palette == nil {
+		palette = DepthPalette
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for idx := 0; idx < len(img.Pix); idx += 4 {
+		img.Pix[idx+3] = 0xff
+	}
+
+	delR := (maxR - minR) / float64(width)
+	delI := (maxI - minI) / float64(height)
+
+	for idx, c := range this {
+		x := int(math.Round((real(c) - minR) / delR))
+		y := int(math.Round((imag(c) - minI) / delI))
+		if x < 0 {
+			x = 0
+		}
+		if x > width-1 {
+			x = width - 1
+		}
+		if y < 0 {
+			y = 0
+		}
+		if y > height-1 {
+			y = height - 1
+		}
+		depth := 0
+		if depths != nil {
+			depth = depths[idx]
+		}
+		img.SetRGBA(x, height-1-y, palette(depth))
+	}
+
+	return img
+}
+
+// DepthHueStep is how many degrees the hue of DepthPalette turns from one
+// depth to the next. Being prime to 360, it takes 360 depths to repeat a hue
+// while giving neighbouring depths clearly different ones.
+const DepthHueStep = 37
+
+// DepthPalette colors depths by cycling through fully saturated hues, turning
+// DepthHueStep degrees per depth.
+func DepthPalette(depth int) color.RGBA {
+	hue := float64(((depth*DepthHueStep)%360+360)%360) / 60
+	x := uint8(math.Round(255 * (1 - math.Abs(math.Mod(hue, 2)-1))))
+	switch int(hue) {
+	case 0:
+		return color.RGBA{0xff, x, 0, 0xff}
+	case 1:
+		return color.RGBA{x, 0xff, 0, 0xff}
+	case 2:
+		return color.RGBA{0, 0xff, x, 0xff}
+	case 3:
+		return color.RGBA{0, x, 0xff, 0xff}
+	case 4:
+		return color.RGBA{x, 0, 0xff, 0xff}
+	}
+	return color.RGBA{0xff, 0, x, 0xff}
+}
+
+// SavePNGFile writes img to path as a PNG.
+func SavePNGFile(img image.Image, path string) error {
+	outfile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(outfile, img)
+	if cerr := outfile.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// FitBounds returns the bounds of the seeds widened by margin times their
+// extent on every side, for framing a render around them. An axis along which
+// all seeds agree is given the extent of the other axis, or of [-2,2] if both
+// collapse, so that the bounds are never empty.
+func (this seedpack) FitBounds(margin float64) (float64, float64, float64, float64) {
+	minR, maxR, minI, maxI := this.Bounds()
+	spanR, spanI := maxR-minR, maxI-minI
+	if spanR == 0 && spanI == 0 {
+		spanR, spanI = FullRegion.MaxR-FullRegion.MinR, FullRegion.MaxI-FullRegion.MinI
+	} else if spanR == 0 {
+		spanR = spanI
+	} else if spanI == 0 {
+		spanI = spanR
+	}
+	midR, midI := (minR+maxR)/2, (minI+maxI)/2
+	return midR - spanR*(0.5+margin), midR + spanR*(0.5+margin), midI - spanI*(0.5+margin), midI + spanI*(0.5+margin)
+}
+
+// RenderEscapeField renders the escape-time field of the single parameter c:
+// every pixel of a width×height image of the [-2,2]×[-2,2] plane is a starting
+// point z, iterated under f with c fixed for at most limit iterations or until
+// it leaves the squared bailout radius b. Points escaping later are drawn
+// brighter; points that never escape are black.
+func RenderEscapeField(c complex128, width, height, limit int, b float64, f Formula) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	delR := (FullRegion.MaxR - FullRegion.MinR) / float64(width)
+	delI := (FullRegion.MaxI - FullRegion.MinI) / float64(height)
+
+	for y := 0; y < height; y++ {
+		zi := FullRegion.MaxI - (float64(y)+0.5)*delI
+		for x := 0; x < width; x++ {
+			z := complex(FullRegion.MinR+(float64(x)+0.5)*delR, zi)
+			shade := uint8(0)
+			for i := 1; i <= limit; i++ {
+				z = f.Step(z, c)
+				if real(z)*real(z)+imag(z)*imag(z) > b {
+					shade = uint8(math.Round(255 * math.Sqrt(float64(i)/float64(limit))))
+					break
+				}
+			}
+			img.SetRGBA(x, y, color.RGBA{shade, shade, shade, 0xff})
+		}
+	}
+
+	return img
+}
+
+// RenderRegion renders the escape-time field of the region as a width×height
+// image, iterating every pixel's c under f with escapeDepth up to max for the
+// squared bailout b and squared periodicity tolerance t, split across threads
+// goroutines. Pixels with depths in [min, max], where seeds would be found,
+// are colored by DepthPalette; shallower pixels are gray, brighter the deeper
+// they are, and pixels deeper than max or inside the set are black.
+func RenderRegion(region Region, width, height, min, max int, b, t float64, f Formula, threads int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	delR := (region.MaxR - region.MinR) / float64(width)
+	delI := (region.MaxI - region.MinI) / float64(height)
+
+	var workers sync.WaitGroup
+	rows := make(chan int, height)
+	for y := 0; y < height; y++ {
+		rows <- y
+	}
+	close(rows)
+	for w := 0; w < threads; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for y := range rows {
+				ci := region.MaxI - (float64(y)+0.5)*delI
+				for x := 0; x < width; x++ {
+					c := complex(region.MinR+(float64(x)+0.5)*delR, ci)
+					depth, _ := escapeDepth(c, max, b, t, f, nil)
+					pixel := color.RGBA{0, 0, 0, 0xff}
+					if depth >= min && depth <= max {
+						pixel = DepthPalette(depth)
+					} else if depth >= 0 && depth < min {
+						shade := uint8(math.Round(160 * math.Sqrt(float64(depth)/float64(min))))
+						pixel = color.RGBA{shade, shade, shade, 0xff}
+					}
+					img.SetRGBA(x, y, pixel)
+				}
+			}
+		}()
+	}
+	workers.Wait()
+
+	return img
+}
+
+// AnimationFrames returns the parameters of an animation that morphs between
+// consecutive seeds, taking framesPerStep frames to move linearly from each
+// seed to the next and ending on the last seed.
+func (this seedpack) AnimationFrames(framesPerStep int) []complex128 {
+	if len(this) == 0 {
+		return nil
+	}
+
+	frames := make([]complex128, 0, (len(this)-1)*framesPerStep+1)
+	for idx := 0; idx < len(this)-1; idx++ {
+		for k := 0; k < framesPerStep; k++ {
+			t := float64(k) / float64(framesPerStep)
+			frames = append(frames, this[idx]+complex(t, 0)*(this[idx+1]-this[idx]))
+		}
+	}
+	return append(frames, this[len(this)-1])
+}
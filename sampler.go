@@ -0,0 +1,174 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Candidate Sampling
+
+// A Sampler generates the candidate points a Miner iterates.
+type Sampler interface {
+	// Stream returns a function yielding successive candidates in region for
+	// one worker. r is that worker's own random source. A Miner creates the
+	// streams of its workers one after another, in worker order, so streams
+	// that depend only on r and that order keep a seeded run reproducible.
+	Stream(region Region, r *rand.Rand) func() complex128
+}
+
+// RandomSampler draws every candidate independently and uniformly from the
+// region using the worker's random source.
+type RandomSampler struct{}
+
+func (RandomSampler) Stream(region Region, r *rand.Rand) func() complex128 {
+	return func() complex128 {
+		return region.Sample(r)
+	}
+}
+
+// HaltonSampler draws candidates from the 2D Halton sequence in bases 2 and 3,
+// which covers the region far more evenly than independent uniform draws.
+// Every stream walks a segment of the sequence of its own, HaltonSegment
+// indices long, so no candidate is iterated twice and what a worker draws
+// does not depend on how fast the others draw.
+type HaltonSampler struct {
+	itsStart   uint64
+	itsStreams atomic.Uint64
+}
+
+// HaltonSegment is the length of the segment of the Halton sequence each
+// stream of a HaltonSampler walks, more indices than any run draws.
+const HaltonSegment = 1 << 40
+
+// NewHaltonSampler returns a HaltonSampler whose sequence starts at index
+// start. Starting at a random index keeps separate runs, such as a resumed
+// one, from retracing the same candidates.
+func NewHaltonSampler(start uint64) *HaltonSampler {
+	return &HaltonSampler{itsStart: start}
+}
+
+func (this *HaltonSampler) Stream(region Region, r *rand.Rand) func() complex128 {
+	i := this.itsStart + (this.itsStreams.Add(1)-1)*HaltonSegment
+	return func() complex128 {
+		i++
+		return complex(region.MinR+radicalInverse(i, 2)*(region.MaxR-region.MinR), region.MinI+radicalInverse(i, 3)*(region.MaxI-region.MinI))
+	}
+}
+
+// BiasedSampler rejects the candidates of another sampler unless they lie in
+// a guidemap cell that is marked or borders a marked one. Deep seeds lie near
+// the boundary of the Mandelbrot set, which the marked cells trace, so this
+// spends far fewer draws on the plain interior and far exterior than sampling
+// the whole region. The price is coverage: seeds in cells the guidemap missed
+// entirely, and not next to any it found, are never drawn.
+type BiasedSampler struct {
+	itsBase     Sampler
+	itsGuidemap *Guidemap
+	itsLive     []bool
+}
+
+// NewBiasedSampler returns a BiasedSampler drawing from base and biased by
+// the cells marked in guidemap when it is called; cells marked later do not
+// widen the bias.
+func NewBiasedSampler(base Sampler, guidemap *Guidemap) *BiasedSampler {
+	width, height := guidemap.itsWidth, guidemap.itsHeight
+	live := make([]bool, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !guidemap.getBit(y*width + x) {
+				continue
+			}
+			for ny := y - 1; ny <= y+1; ny++ {
+				for nx := x - 1; nx <= x+1; nx++ {
+					if nx >= 0 && nx < width && ny >= 0 && ny < height {
+						live[ny*width+nx] = true
+					}
+				}
+			}
+		}
+	}
+	return &BiasedSampler{base, guidemap, live}
+}
+
+// Stream gives up rejecting after 1024 candidates in a row, so that a region
+// without any live cells is still sampled, if uselessly.
+func (this *BiasedSampler) Stream(region Region, r *rand.Rand) func() complex128 {
+	base := this.itsBase.Stream(region, r)
+	return func() complex128 {
+		c := base()
+		for tries := 1; tries < 1024 && !this.itsLive[this.itsGuidemap.cell(c)]; tries++ {
+			c = base()
+		}
+		return c
+	}
+}
+
+// detachedSampler returns a sampler drawing like s whose streams leave those
+// of s untouched, for trial runs whose draws must not shift the candidates of
+// the run proper.
+func detachedSampler(s Sampler) Sampler {
+	switch s := s.(type) {
+	case *HaltonSampler:
+		return NewHaltonSampler(s.itsStart)
+	case *BiasedSampler:
+		return &BiasedSampler{detachedSampler(s.itsBase), s.itsGuidemap, s.itsLive}
+	}
+	return s
+}
+
+// NeighborProbes is how many points are probed around a seed for every
+// neighbor sought, bounding the effort spent around seeds with few neighbors
+// in the depth range.
+const NeighborProbes = 4
+
+// Perturbation returns an offset drawn uniformly from the disc of the given
+// radius about the origin.
+func Perturbation(r *rand.Rand, radius float64) complex128 {
+	rho := radius * math.Sqrt(r.Float64())
+	theta := 2 * math.Pi * r.Float64()
+	return complex(rho*math.Cos(theta), rho*math.Sin(theta))
+}
+
+// radicalInverse mirrors the base b digits of i about the radix point,
+// giving the i-th element of the van der Corput sequence in base b.
+func radicalInverse(i, b uint64) float64 {
+	inv := 1.00 / float64(b)
+	f, x := inv, 0.00
+	for ; i > 0; i /= b {
+		x += float64(i%b) * f
+		f *= inv
+	}
+	return x
+}
+
+// ParseSampler returns the sampler called name, "random" or "halton". A
+// halton sampler starts at a random point of its sequence drawn from r.
+func ParseSampler(name string, r *rand.Rand) (Sampler, error) {
+	switch name {
+	case "random":
+		return RandomSampler{}, nil
+	case "halton":
+		return NewHaltonSampler(uint64(r.Int63n(1 << 40))), nil
+	}
+	return nil, errors.New("unknown sampler \"" + name + "\"; expected random or halton")
+}
@@ -0,0 +1,208 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"math"
+)
+
+// Spatial Lookups
+
+// Bounds returns the smallest rectangle minR, maxR, minI, maxI containing every
+// seed, or the [-2,2]×[-2,2] plane if the seedpack is empty.
+func (this seedpack) Bounds() (float64, float64, float64, float64) {
+	if len(this) == 0 {
+		return FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI
+	}
+
+	minR, maxR := real(this[0]), real(this[0])
+	minI, maxI := imag(this[0]), imag(this[0])
+	for _, c := range this {
+		minR, maxR = math.Min(minR, real(c)), math.Max(maxR, real(c))
+		minI, maxI = math.Min(minI, imag(c)), math.Max(maxI, imag(c))
+	}
+	return minR, maxR, minI, maxI
+}
+
+// SeedIndex buckets the seeds of a seedpack into a grid over their bounds,
+// about one seed per cell, so that the seed nearest to a point can be found
+// without comparing against every seed.
+type SeedIndex struct {
+	itsSeeds            seedpack
+	itsWidth, itsHeight int
+	itsMinR, itsMinI    float64
+	itsDelR, itsDelI    float64
+	itsBuckets          [][]int
+}
+
+// Index builds a SeedIndex over the seedpack, which must not be modified while
+// the index is in use.
+func (this seedpack) Index() *SeedIndex {
+	index := &SeedIndex{itsSeeds: this, itsWidth: 1, itsHeight: 1}
+	if len(this) == 0 {
+		return index
+	}
+
+	minR, maxR, minI, maxI := this.Bounds()
+
+	// An axis along which all seeds agree gets a single cell of infinite
+	// extent.
+	side := int(math.Ceil(math.Sqrt(float64(len(this)))))
+	index.itsMinR, index.itsDelR = minR, math.Inf(1)
+	if maxR > minR {
+		index.itsWidth = side
+		index.itsDelR = (maxR - minR) / float64(side)
+	}
+	index.itsMinI, index.itsDelI = minI, math.Inf(1)
+	if maxI > minI {
+		index.itsHeight = side
+		index.itsDelI = (maxI - minI) / float64(side)
+	}
+
+	index.itsBuckets = make([][]int, index.itsWidth*index.itsHeight)
+	for idx, c := range this {
+		x, y := index.cell(c)
+		bucket := y*index.itsWidth + x
+		index.itsBuckets[bucket] = append(index.itsBuckets[bucket], idx)
+	}
+	return index
+}
+
+// cell returns the grid coordinates of the cell containing c, clamped to the
+// grid.
+func (this *SeedIndex) cell(c complex128) (int, int) {
+	x := int((real(c) - this.itsMinR) / this.itsDelR)
+	y := int((imag(c) - this.itsMinI) / this.itsDelI)
+	x = int(math.Max(0, math.Min(float64(x), float64(this.itsWidth-1))))
+	y = int(math.Max(0, math.Min(float64(y), float64(this.itsHeight-1))))
+	return x, y
+}
+
+// Nearest returns the seed closest to c and its index in the seedpack, or
+// index -1 if the seedpack is empty. Ties go to the seed found first.
+func (this *SeedIndex) Nearest(c complex128) (complex128, int) {
+	return this.nearestExcept(c, -1)
+}
+
+// nearestExcept is Nearest ignoring the seed at index skip.
+func (this *SeedIndex) nearestExcept(c complex128, skip int) (complex128, int) {
+	cx, cy := this.cell(c)
+	best, bestdist := -1, math.Inf(1)
+	step := math.Min(this.itsDelR, this.itsDelI)
+
+	// Search rings of cells around the cell of c, stopping once no seed in a
+	// farther ring could be closer than the best found so far.
+	for ring := 0; ring <= this.itsWidth || ring <= this.itsHeight; ring++ {
+		if best >= 0 && float64(ring-1)*step >= math.Sqrt(bestdist) {
+			break
+		}
+		for y := cy - ring; y <= cy+ring; y++ {
+			if y < 0 || y >= this.itsHeight {
+				continue
+			}
+			for x := cx - ring; x <= cx+ring; x++ {
+				if x < 0 || x >= this.itsWidth {
+					continue
+				}
+				if x != cx-ring && x != cx+ring && y != cy-ring && y != cy+ring {
+					continue
+				}
+				for _, idx := range this.itsBuckets[y*this.itsWidth+x] {
+					if idx == skip {
+						continue
+					}
+					d := this.itsSeeds[idx] - c
+					if dist := real(d)*real(d) + imag(d)*imag(d); dist < bestdist {
+						best, bestdist = idx, dist
+					}
+				}
+			}
+		}
+	}
+
+	if best < 0 {
+		return 0, -1
+	}
+	return this.itsSeeds[best], best
+}
+
+// Nearest returns the seed closest to c and its index, or index -1 if the
+// seedpack is empty. It builds a SeedIndex for the one query; build one with
+// Index instead to look up many points.
+func (this seedpack) Nearest(c complex128) (complex128, int) {
+	return this.Index().Nearest(c)
+}
+
+// SpacingStats returns the mean and the least distance from every seed to the
+// nearest other seed, or 0 for both if there are fewer than two seeds. A tiny
+// minimum betrays clusters, which -min-separation breaks up; a large mean,
+// sparse coverage.
+func (this seedpack) SpacingStats() (float64, float64) {
+	if len(this) < 2 {
+		return 0, 0
+	}
+
+	index := this.Index()
+	sum, least := 0.0, math.Inf(1)
+	for idx, c := range this {
+		nearest, _ := index.nearestExcept(c, idx)
+		dist := math.Hypot(real(nearest-c), imag(nearest-c))
+		sum += dist
+		least = math.Min(least, dist)
+	}
+	return sum / float64(len(this)), least
+}
+
+// separationHash remembers points in square buckets as wide as the minimum
+// separation, so that whether a point lies within that distance of any
+// remembered one only takes looking at the 3x3 buckets around it.
+type separationHash struct {
+	itsSeparation float64
+	itsBuckets    map[[2]int64][]complex128
+}
+
+func newSeparationHash(separation float64) *separationHash {
+	return &separationHash{separation, make(map[[2]int64][]complex128)}
+}
+
+func (this *separationHash) bucket(c complex128) [2]int64 {
+	return [2]int64{int64(math.Floor(real(c) / this.itsSeparation)), int64(math.Floor(imag(c) / this.itsSeparation))}
+}
+
+// Crowded reports whether c lies within the separation of a remembered point.
+func (this *separationHash) Crowded(c complex128) bool {
+	key := this.bucket(c)
+	for dx := int64(-1); dx <= 1; dx++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for _, other := range this.itsBuckets[[2]int64{key[0] + dx, key[1] + dy}] {
+				d := other - c
+				if real(d)*real(d)+imag(d)*imag(d) < this.itsSeparation*this.itsSeparation {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Add remembers c.
+func (this *separationHash) Add(c complex128) {
+	key := this.bucket(c)
+	this.itsBuckets[key] = append(this.itsBuckets[key], c)
+}
@@ -0,0 +1,212 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Depth Histogram
+
+// DepthHistogram counts how many of depths fall into each of buckets equally
+// wide ranges spanning [min, max]. Depths outside [min, max] are ignored.
+func DepthHistogram(depths []int, min, max, buckets int) ([]int, error) {
+	if buckets < 1 {
+		return nil, errors.New("number of histogram buckets is less than one")
+	}
+
+	histogram := make([]int, buckets)
+	span := max - min + 1
+	for _, depth := range depths {
+		if depth < min || depth > max {
+			continue
+		}
+		histogram[(depth-min)*buckets/span]++
+	}
+	return histogram, nil
+}
+
+// PrintDepthHistogram prints depths bucketed across [min, max] as an ASCII bar
+// chart, using at most buckets bars.
+func PrintDepthHistogram(depths []int, min, max, buckets int) error {
+	span := max - min + 1
+	if buckets > span {
+		buckets = span
+	}
+	histogram, err := DepthHistogram(depths, min, max, buckets)
+	if err != nil {
+		return err
+	}
+
+	largest := 0
+	for _, count := range histogram {
+		if count > largest {
+			largest = count
+		}
+	}
+
+	width := len(strconv.Itoa(max))
+	fmt.Println("Depth histogram:")
+	for idx, count := range histogram {
+		from := min + idx*span/buckets
+		to := min + (idx+1)*span/buckets - 1
+		bar := 0
+		if largest > 0 {
+			bar = count * 50 / largest
+		}
+		fmt.Println("  " + fmt.Sprintf("%*d", width, from) + " - " + fmt.Sprintf("%*d", width, to) + " | " + strings.Repeat("#", bar) + " " + strconv.Itoa(count))
+	}
+	return nil
+}
+
+// Depth Census
+
+// DepthCensus is the distribution of escape depths over points sampled from a
+// region. Counts maps each depth up to MaxDepth to the number of samples that
+// escaped at it; Interior samples were found to be in the Mandelbrot set and
+// Unescaped ones were still bounded after MaxDepth iterations.
+type DepthCensus struct {
+	Samples   int         `json:"samples"`
+	MaxDepth  int         `json:"maxDepth"`
+	Counts    map[int]int `json:"counts"`
+	Interior  int         `json:"interior"`
+	Unescaped int         `json:"unescaped"`
+}
+
+// CountDepths draws samples points from region with sampler and takes the
+// census of their escape depths under formula f up to max, for the squared
+// bailout radius b and squared periodicity tolerance t. The samples are split
+// among threads workers, each drawing from its own source seeded from r.
+func CountDepths(region Region, sampler Sampler, r *rand.Rand, samples, max int, b, t float64, f Formula, threads int) DepthCensus {
+	census := DepthCensus{Samples: samples, MaxDepth: max, Counts: make(map[int]int)}
+
+	var lock sync.Mutex
+	var workers sync.WaitGroup
+	for w := 0; w < threads; w++ {
+		share := samples / threads
+		if w < samples%threads {
+			share++
+		}
+		workers.Add(1)
+		go func(r *rand.Rand, share int) {
+			defer workers.Done()
+			sample := sampler.Stream(region, r)
+			counts := make(map[int]int)
+			interior, unescaped := 0, 0
+			for j := 0; j < share; j++ {
+				switch depth, _ := escapeDepth(sample(), max, b, t, f, nil); {
+				case depth < 0:
+					interior++
+				case depth > max:
+					unescaped++
+				default:
+					counts[depth]++
+				}
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			for depth, count := range counts {
+				census.Counts[depth] += count
+			}
+			census.Interior += interior
+			census.Unescaped += unescaped
+		}(rand.New(rand.NewSource(r.Int63())), share)
+	}
+	workers.Wait()
+
+	return census
+}
+
+// WriteCSV writes the census to w as depth,count lines in order of depth,
+// followed by the interior and unescaped counts.
+func (this DepthCensus) WriteCSV(w io.Writer) error {
+	depths := make([]int, 0, len(this.Counts))
+	for depth := range this.Counts {
+		depths = append(depths, depth)
+	}
+	sort.Ints(depths)
+
+	var buf strings.Builder
+	buf.WriteString("depth,count\n")
+	for _, depth := range depths {
+		buf.WriteString(strconv.Itoa(depth) + "," + strconv.Itoa(this.Counts[depth]) + "\n")
+	}
+	buf.WriteString("interior," + strconv.Itoa(this.Interior) + "\n")
+	buf.WriteString(">" + strconv.Itoa(this.MaxDepth) + "," + strconv.Itoa(this.Unescaped) + "\n")
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// Benchmark
+
+// The benchmark workload: BenchSeeds seeds with depths in [BenchMin,
+// BenchMax], drawn from a source seeded with BenchSeed and guided by a
+// BenchGuideSize×BenchGuideSize guidemap of BenchGuideSamples points.
+const (
+	BenchSeeds        = 100000
+	BenchMin          = 50
+	BenchMax          = 200
+	BenchSeed         = 1
+	BenchGuideSize    = 51
+	BenchGuideSamples = 1000000
+)
+
+// Benchmark mines the benchmark workload on threads workers and returns the
+// statistics of the run. The guidemap rejects candidates as it does in a
+// real run, but it is generated from a fixed number of points rather than
+// for a fixed time, and the random source is fixed, so that every machine
+// does the same work: as the miner takes the seeds from its workers in turn,
+// a given number of threads finds the same seeds from run to run, and only
+// the time taken differs (and, by the few candidates drawn while the workers
+// are being stopped, the candidate count). Generating the guidemap is not
+// timed.
+func Benchmark(threads int) Stats {
+	r := rand.New(rand.NewSource(BenchSeed))
+	miner := NewMiner(BenchSeeds, BenchMin, BenchMax)
+	miner.Threads = threads
+	miner.Guidemap = NewGuidemap(BenchGuideSize, BenchGuideSize, FullRegion.MinR, FullRegion.MaxR, FullRegion.MinI, FullRegion.MaxI, false)
+	miner.Guidemap.GenerateSamples(BenchGuideSamples, r, MandelbrotFormula)
+	miner.Rand = r
+	_, stats, _ := miner.MineSeeds(context.Background())
+	return stats
+}
+
+// WriteBenchmark writes the throughput of a Benchmark run on threads workers
+// to w.
+func WriteBenchmark(w io.Writer, stats Stats, threads int) error {
+	seconds := stats.Elapsed.Seconds()
+	var buf strings.Builder
+	buf.WriteString("Benchmark: " + strconv.Itoa(stats.Found) + " seeds with depths between " + strconv.Itoa(BenchMin) + " - " + strconv.Itoa(BenchMax) + " on " + strconv.Itoa(threads) + " threads in " + strconv.FormatFloat(seconds, 'f', 3, 64) + "s.\n")
+	buf.WriteString("  seeds per second:      " + strconv.FormatFloat(float64(stats.Found)/seconds, 'f', 1, 64) + "\n")
+	buf.WriteString("  candidates per second: " + strconv.FormatFloat(float64(stats.Candidates)/seconds, 'f', 1, 64) + "\n")
+	if stats.Candidates > 0 {
+		buf.WriteString("  acceptance ratio:      " + strconv.FormatFloat(float64(stats.Found)/float64(stats.Candidates), 'f', 6, 64) + "\n")
+	}
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
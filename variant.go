@@ -0,0 +1,136 @@
+package main
+
+/*****************************************************************************
+ *  EMSMiner mines ectocopial Mandelbrot seeds used to create Anthropobrots. *
+ *  Copyright © 2020 Daïm Aggott-Hönsch                                      *
+ *                                                                           *
+ *  This program is free software: you can redistribute it and/or modify     *
+ *  it under the terms of the GNU General Public License as published by     *
+ *  the Free Software Foundation, either version 3 of the License, or        *
+ *  (at your option) any later version.                                      *
+ *                                                                           *
+ *  This program is distributed in the hope that it will be useful,          *
+ *  but WITHOUT ANY WARRANTY; without even the implied warranty of           *
+ *  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the            *
+ *  GNU General Public License for more details.                             *
+ *                                                                           *
+ *  You should have received a copy of the GNU General Public License        *
+ *  along with this program.  If not, see <https://www.gnu.org/licenses/>.   *
+ *****************************************************************************/
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Fractal Variants
+
+// Variant selects the recurrence iterated to find escape depths.
+type Variant int
+
+const (
+	// Mandelbrot iterates z = z*z + c.
+	Mandelbrot Variant = iota
+
+	// Tricorn, also called the Mandelbar set, iterates z = conj(z)*conj(z) + c.
+	// Like the Mandelbrot set it is symmetric about the real axis, but it has
+	// no main cardioid or period-2 bulb to skip.
+	Tricorn
+)
+
+func (this Variant) String() string {
+	switch this {
+	case Mandelbrot:
+		return "mandelbrot"
+	case Tricorn:
+		return "tricorn"
+	}
+	return "unknown"
+}
+
+// ParseVariant returns the variant called name, "mandelbrot" or "tricorn".
+func ParseVariant(name string) (Variant, error) {
+	switch name {
+	case "mandelbrot":
+		return Mandelbrot, nil
+	case "tricorn":
+		return Tricorn, nil
+	}
+	return Mandelbrot, errors.New("unknown variant \"" + name + "\"; expected mandelbrot or tricorn")
+}
+
+// Formula is the recurrence iterated: z = z^Power + c for the Mandelbrot
+// variant, or z = conj(z)^Power + c for the Tricorn. Powers above 2 give the
+// multibrots and multicorns.
+type Formula struct {
+	Variant Variant
+	Power   int
+}
+
+// MandelbrotFormula is the classic z = z*z + c.
+var MandelbrotFormula = Formula{Mandelbrot, 2}
+
+// NewFormula returns the formula of the given variant and power, which must be
+// at least 2.
+func NewFormula(variant Variant, power int) (Formula, error) {
+	if power < 2 {
+		return Formula{}, errors.New("power " + strconv.Itoa(power) + " is less than 2")
+	}
+	return Formula{variant, power}, nil
+}
+
+func (this Formula) String() string {
+	if this.Power == 2 {
+		return this.Variant.String()
+	}
+	return this.Variant.String() + " of power " + strconv.Itoa(this.Power)
+}
+
+// Step returns the point following z in the orbit of c.
+func (this Formula) Step(z, c complex128) complex128 {
+	if this.Variant == Tricorn {
+		z = complex(real(z), -imag(z))
+	}
+	if this.Power == 2 {
+		return z*z + c
+	}
+	return this.pow(z) + c
+}
+
+// Derivative returns the derivative with respect to c of the point following
+// z in the orbit of c, given the derivative dz of z. The tricorn is not
+// holomorphic; its conjugated derivative has the same magnitude, which is all
+// a distance estimate needs.
+func (this Formula) Derivative(z, dz complex128) complex128 {
+	if this.Variant == Tricorn {
+		z, dz = complex(real(z), -imag(z)), complex(real(dz), -imag(dz))
+	}
+	if this.Power == 2 {
+		return 2*z*dz + 1
+	}
+	return complex(float64(this.Power), 0)*Formula{Mandelbrot, this.Power - 1}.pow(z)*dz + 1
+}
+
+// pow raises z to the power of the formula by repeated multiplication, which
+// unlike cmplx.Pow is exact for the real axis and cheap for small powers.
+func (this Formula) pow(z complex128) complex128 {
+	w := z
+	for k := 1; k < this.Power; k++ {
+		w *= z
+	}
+	return w
+}
+
+// SkipsInterior reports whether c is known to lie in the set without being
+// iterated. Only the quadratic Mandelbrot set has a cardioid and bulb test.
+func (this Formula) SkipsInterior(c complex128) bool {
+	return this.Variant == Mandelbrot && this.Power == 2 && CheckInMainCardioidOrBulb(c)
+}
+
+// Bounds returns the smallest square about the origin containing the whole
+// set. Every point of a set of power p lies within 2^(1/(p-1)) of the origin.
+func (this Formula) Bounds() Region {
+	r := math.Pow(2, 1/float64(this.Power-1))
+	return Region{-r, r, -r, r}
+}